@@ -0,0 +1,67 @@
+package logwriter
+
+import "time"
+
+// adaptBuffer resizes empty buffer after flush of n bytes started at time started,
+// so at current write rate the next flush happens in about config.BufferFlushTarget.
+// Buffer grows if flush takes more than tenth of time between flushes. Size stays
+// within [config.BufferSize, config.BufferMaxSize]. Must be called with lw locked.
+func (lw *LogWriter) adaptBuffer(n int, started time.Time) {
+
+	now := lw.clock.Now()
+	elapsed := started.Sub(lw.lastFlush)
+	latency := now.Sub(started)
+	lw.lastFlush = now
+
+	min, max := lw.config.BufferSize, lw.config.BufferMaxSize
+	if min == 0 || max <= min || elapsed <= 0 {
+		return
+	}
+
+	target := lw.config.BufferFlushTarget
+	if target <= 0 {
+		target = time.Second
+	}
+
+	// bytes expected to be written within target interval
+	size := int(float64(n) * float64(target) / float64(elapsed))
+
+	// slow disk, write bigger chunks
+	if latency*10 > elapsed && size < 2*len(lw.buffer) {
+		size = 2 * len(lw.buffer)
+	}
+
+	if size < min {
+		size = min
+	} else if size > max {
+		size = max
+	}
+
+	// ignore small fluctuations of write rate
+	cur := len(lw.buffer)
+	if size > cur*5/4 || size < cur*3/4 || size == min || size == max {
+		lw.resizeBuffer(size)
+	}
+}
+
+// resizeBuffer sets length of empty buffer to size. Buffer memory is reused unless
+// it is too small or twice bigger than required. Buffers of flusher are resized too.
+func (lw *LogWriter) resizeBuffer(size int) {
+
+	if lw.flusher != nil {
+		lw.flusher.resize(size)
+	}
+
+	if fits(lw.buffer, size) {
+		lw.buffer = lw.buffer[:size]
+		return
+	}
+
+	lw.buffer = make([]byte, size)
+}
+
+// fits returns true if memory of buf can be reused for buffer of size bytes
+func fits(buf []byte, size int) bool {
+	c := cap(buf)
+	return c >= size && c <= 2*size
+}
@@ -0,0 +1,283 @@
+package logwriter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrWriterClosed is returned by Write() called after Close().
+var ErrWriterClosed = errors.New("logwriter: writer closed")
+
+// OverflowPolicy defines AsyncLogWriter behaviour when queue is full.
+type OverflowPolicy int
+
+// Supported overflow policies
+const (
+	// OverflowBlock makes Write() wait for free space in the queue
+	OverflowBlock OverflowPolicy = 0
+
+	// OverflowDropNewest drops log item passed to Write()
+	OverflowDropNewest OverflowPolicy = 1
+
+	// OverflowDropOldest drops the oldest log item in the queue
+	OverflowDropOldest OverflowPolicy = 2
+
+	// OverflowDropLow drops log item passed to Write() if its priority is lower than
+	// NormalPriority. Write() waits for free space for other log items
+	OverflowDropLow OverflowPolicy = 3
+)
+
+// Priority of log item, see OverflowDropLow.
+type Priority int
+
+// Log item priorities
+const (
+	LowPriority    Priority = -1
+	NormalPriority Priority = 0
+	HighPriority   Priority = 1
+)
+
+// AsyncConfig holds parameters of AsyncLogWriter.
+type AsyncConfig struct {
+	// Queue length in log items. Default value is 1024
+	QueueLen int
+
+	// What to do when queue is full
+	Overflow OverflowPolicy
+
+	// Priority classifies log item passed to Write(). Items are NormalPriority if nil
+	Priority func(p []byte) Priority
+
+	// DropNotice returns log item to be written when queue becomes empty after
+	// items were dropped. Nothing is written if nil. See DefaultDropNotice()
+	DropNotice func(dropped uint64) []byte
+
+	// ErrFunc is called when write to underlying writer fails
+	ErrFunc func(error)
+}
+
+// DefaultDropNotice formats line "logwriter: N log items dropped".
+func DefaultDropNotice(dropped uint64) []byte {
+	return []byte(fmt.Sprintf("logwriter: %d log items dropped\n", dropped))
+}
+
+// AsyncLogWriter copies log items into a queue served by single routine writing them
+// into underlying writer, usually LogWriter. Write() never waits for disk i/o unless
+// OverflowBlock policy applied to full queue.
+type AsyncLogWriter struct {
+	w      io.Writer
+	config AsyncConfig
+
+	queue chan *[]byte
+	pool  sync.Pool
+
+	// number of dropped items, total and mentioned by DropNotice
+	dropped  uint64
+	reported uint64
+
+	// guards queue closing
+	mu     sync.RWMutex
+	closed bool
+
+	done chan struct{}
+}
+
+// NewAsyncLogWriter creates AsyncLogWriter writing into w and starts its routine.
+func NewAsyncLogWriter(w io.Writer, cfg *AsyncConfig) *AsyncLogWriter {
+
+	aw := &AsyncLogWriter{w: w, done: make(chan struct{})}
+
+	if cfg != nil {
+		aw.config = *cfg
+	}
+
+	if aw.config.QueueLen <= 0 {
+		aw.config.QueueLen = 1024
+	}
+
+	aw.queue = make(chan *[]byte, aw.config.QueueLen)
+	aw.pool.New = func() interface{} {
+		b := make([]byte, 0, 512)
+		return &b
+	}
+
+	go aw.run()
+
+	return aw
+}
+
+// Write copies p into the queue. Returns len(p) even if p dropped by overflow policy.
+func (aw *AsyncLogWriter) Write(p []byte) (int, error) {
+
+	prio := NormalPriority
+	if aw.config.Priority != nil {
+		prio = aw.config.Priority(p)
+	}
+
+	return aw.WritePriority(p, prio)
+}
+
+// WritePriority copies p into the queue, prio overrides AsyncConfig.Priority.
+func (aw *AsyncLogWriter) WritePriority(p []byte, prio Priority) (int, error) {
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	item := aw.pool.Get().(*[]byte)
+	*item = append((*item)[:0], p...)
+
+	aw.mu.RLock()
+	defer aw.mu.RUnlock()
+
+	if aw.closed {
+		aw.pool.Put(item)
+		return 0, ErrWriterClosed
+	}
+
+	// fast path, there is space in the queue
+	select {
+	case aw.queue <- item:
+		return len(p), nil
+	default:
+	}
+
+	switch {
+	case aw.config.Overflow == OverflowDropNewest,
+		aw.config.Overflow == OverflowDropLow && prio < NormalPriority:
+		aw.drop(item)
+
+	case aw.config.Overflow == OverflowDropOldest:
+		for {
+			select {
+			case aw.queue <- item:
+				return len(p), nil
+			default:
+			}
+
+			select {
+			case old := <-aw.queue:
+				aw.drop(old)
+			default:
+			}
+		}
+
+	default:
+		aw.queue <- item
+	}
+
+	return len(p), nil
+}
+
+// Dropped returns number of log items dropped by overflow policy.
+func (aw *AsyncLogWriter) Dropped() uint64 {
+	return atomic.LoadUint64(&aw.dropped)
+}
+
+// Close writes queued items, stops routine and closes underlying writer if it
+// implements io.Closer.
+func (aw *AsyncLogWriter) Close() error {
+
+	aw.mu.Lock()
+	if aw.closed {
+		aw.mu.Unlock()
+		return ErrWriterClosed
+	}
+	aw.closed = true
+	close(aw.queue)
+	aw.mu.Unlock()
+
+	<-aw.done
+
+	if c, ok := aw.w.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+func (aw *AsyncLogWriter) drop(item *[]byte) {
+	atomic.AddUint64(&aw.dropped, 1)
+	aw.pool.Put(item)
+}
+
+// maxBatch limits number of queued log items written at once
+const maxBatch = 64
+
+func (aw *AsyncLogWriter) run() {
+
+	var (
+		items = make([]*[]byte, 0, maxBatch)
+		bufs  = make([][]byte, 0, maxBatch)
+	)
+
+	for item := range aw.queue {
+
+		// take items already queued, so they are written with single syscall
+		items = append(items[:0], item)
+	batch:
+		for len(items) < maxBatch {
+			select {
+			case item, ok := <-aw.queue:
+				if !ok {
+					break batch
+				}
+				items = append(items, item)
+			default:
+				break batch
+			}
+		}
+
+		bufs = bufs[:0]
+		for _, item := range items {
+			bufs = append(bufs, *item)
+		}
+
+		aw.write(bufs)
+
+		for _, item := range items {
+			aw.pool.Put(item)
+		}
+
+		if len(aw.queue) == 0 {
+			aw.reportDropped()
+		}
+	}
+
+	aw.reportDropped()
+	close(aw.done)
+}
+
+func (aw *AsyncLogWriter) write(bufs [][]byte) {
+
+	var err error
+	switch w := aw.w.(type) {
+	case *LogWriter:
+		_, err = w.writeBuffers(bufs)
+	default:
+		_, err = writeBuffers(w, bufs)
+	}
+
+	if err != nil && aw.config.ErrFunc != nil {
+		aw.config.ErrFunc(err)
+	}
+}
+
+// reportDropped writes DropNotice if there are not reported drops
+func (aw *AsyncLogWriter) reportDropped() {
+
+	if aw.config.DropNotice == nil {
+		return
+	}
+
+	dropped := atomic.LoadUint64(&aw.dropped)
+	if dropped == aw.reported {
+		return
+	}
+
+	aw.write([][]byte{aw.config.DropNotice(dropped - aw.reported)})
+	aw.reported = dropped
+}
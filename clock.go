@@ -0,0 +1,54 @@
+package logwriter
+
+import "time"
+
+// Clock is a source of time for LogWriter. It drives buffer flush and freeze timers
+// and provides time stamps for cold file names. Replace it in Config to make time
+// based behaviour deterministic, see package logwritertest.
+type Clock interface {
+	// Now returns current time
+	Now() time.Time
+
+	// NewTimer creates Timer which fires once after duration d
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is a single event timer created by Clock. Methods follow time.Timer semantic.
+type Timer interface {
+	// C returns channel where the time is delivered when timer fires
+	C() <-chan time.Time
+
+	// Stop prevents timer from firing. Returns false if timer already fired or stopped
+	Stop() bool
+
+	// Reset changes timer to fire after duration d. Returns true if timer was active
+	Reset(d time.Duration) bool
+}
+
+// SystemClock is Clock backed by package time. It is used if Config.Clock is nil.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+func clockOrDefault(c Clock) Clock {
+	if c == nil {
+		return SystemClock
+	}
+	return c
+}
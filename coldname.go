@@ -0,0 +1,180 @@
+package logwriter
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// coldNames returns full names of cold file, plain and compressed, having sequence number seq
+type coldNames func(seq int) (plain, compressed string)
+
+// nameTemplates parses hot file name template, cold file full name template and ColdPath
+// template of cfg. Defaults are used for empty templates
+func nameTemplates(cfg *Config) (hot, cold, coldDir *NameTemplate, err error) {
+
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	hotText := cfg.HotNameTemplate
+	if hotText == "" {
+		hotText = DefaultHotNameTemplate
+	}
+
+	coldText := cfg.ColdNameTemplate
+	if coldText == "" {
+		coldText = DefaultColdNameTemplate
+	}
+
+	if hot, err = ParseNameTemplate(hotText); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// hot file of previous run must be found by the same name
+	for _, token := range []string{"pid", "seq", "start", "end", "first", "last"} {
+		if hot.has(token) {
+			return nil, nil, nil, fmt.Errorf("logwriter: token {%s} is not allowed in hot name template %q", token, hotText)
+		}
+	}
+
+	if cold, err = ParseNameTemplate(filepath.Join(cfg.ColdPath, coldText)); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if coldDir, err = parseNameTemplate(cfg.ColdPath, false); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return hot, cold, coldDir, nil
+}
+
+// setNameTemplates applies name templates of lw.config. Hot file keeps its name until freeze
+func (lw *LogWriter) setNameTemplates() (err error) {
+
+	if lw.hostname == "" {
+		lw.hostname, _ = os.Hostname()
+	}
+
+	lw.hotTemplate, lw.coldTemplate, lw.coldDirTemplate, err = nameTemplates(&lw.config)
+	if err != nil {
+		return err
+	}
+
+	// names have times of clock location
+	loc := lw.clock.Now().Location()
+	lw.hotTemplate = lw.hotTemplate.In(loc)
+	lw.coldTemplate = lw.coldTemplate.In(loc)
+	lw.coldDirTemplate = lw.coldDirTemplate.In(loc)
+
+	lw.trackWrites = lw.coldTemplate.has("first") || lw.coldTemplate.has("last")
+	return nil
+}
+
+// touch registers write into hot file at time now. Must be called with lw locked.
+func (lw *LogWriter) touch(now time.Time) {
+
+	lw.lastWrite = now
+
+	if lw.hotFirst.IsZero() {
+		lw.hotFirst = now
+	}
+	lw.hotLast = now
+}
+
+// nameValues returns values of name template tokens, but sequence number and codec
+func (lw *LogWriter) nameValues(ext string) NameValues {
+
+	// nothing written into hot file
+	first, last := lw.hotFirst, lw.hotLast
+	if first.IsZero() {
+		first, last = lw.hotStarted, lw.hotStarted
+	}
+
+	return NameValues{
+		UID:      lw.uid,
+		Hostname: lw.hostname,
+		PID:      os.Getpid(),
+		Start:    lw.hotStarted,
+		End:      lw.clock.Now(),
+		First:    first,
+		Last:     last,
+		Ext:      ext,
+	}
+}
+
+// hotName returns full name of hot file to be created
+func (lw *LogWriter) hotName() string {
+
+	return filepath.Join(lw.config.HotPath, lw.hotTemplate.Format(lw.nameValues(lw.hotFileExtension)))
+}
+
+// coldName returns names of cold file to be made of frozen hot file and sequence number
+// to be used. Sequence number grows while the same name is given by template or formatter
+// (freezes repeat within time resolution of name), names of existing files are skipped.
+func (lw *LogWriter) coldName() (coldNames, int) {
+
+	var (
+		names coldNames
+		v     = lw.nameValues(lw.coldFileExtension)
+	)
+
+	if lw.coldFileNameFormatter != nil {
+		dir := lw.coldDirTemplate.Format(v)
+		name := lw.coldFileNameFormatter(lw.uid, lw.coldFileExtension, lw.config.FreezeInterval)
+		names = func(seq int) (string, string) {
+			plain := filepath.Join(dir, seqName(name, seq))
+			return plain, plain + "." + CompressedColdFileExtension
+		}
+	} else {
+		t := lw.coldTemplate
+		names = func(seq int) (string, string) {
+			v := v
+			v.Seq, v.Codec = seq, ""
+			plain := t.Format(v)
+			v.Codec = CompressedColdFileExtension
+			return plain, t.Format(v)
+		}
+	}
+
+	base, _ := names(0)
+	if base == lw.coldBase {
+		lw.coldSeq++
+	} else {
+		lw.coldBase, lw.coldSeq = base, 0
+	}
+
+	for ; ; lw.coldSeq++ {
+		plain, compressed := names(lw.coldSeq)
+		if !fileExists(lw.fs, filepath.Join(lw.config.HotPath, filepath.Base(plain))) &&
+			!fileExists(lw.fs, plain) && !fileExists(lw.fs, compressed) {
+			return names, lw.coldSeq
+		}
+	}
+}
+
+// seqName inserts sequence suffix "-seq" before extension of name, seq 0 keeps name as is
+func seqName(name string, seq int) string {
+
+	if seq == 0 {
+		return name
+	}
+
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "-" + strconv.Itoa(seq) + ext
+}
+
+// freeName returns names having the lowest sequence number starting from seq, so neither
+// plain nor compressed file exists. File from is the one to be renamed, it is not a collision.
+func freeName(fs FS, from string, names coldNames, seq int) (string, string) {
+
+	for ; ; seq++ {
+		plain, compressed := names(seq)
+		if (plain == from || !fileExists(fs, plain)) && !fileExists(fs, compressed) {
+			return plain, compressed
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package logwriter
+
+import "path/filepath"
+
+// Durability defines when LogWriter calls fsync, so written data survives power failure.
+type Durability int
+
+// Supported durability modes. Every mode except DurabilityNone includes SyncOnFreeze
+const (
+	// DurabilityNone leaves data in OS page cache
+	DurabilityNone Durability = 0
+
+	// SyncOnFreeze syncs hot file before it becomes cold, before Close() and folders
+	// after files renamed or created
+	SyncOnFreeze Durability = 1
+
+	// SyncPeriodically syncs hot file every Config.SyncEveryBytes written and
+	// every Config.SyncInterval
+	SyncPeriodically Durability = 2
+
+	// SyncOnFlush syncs hot file after every buffer flush, or every Write() if buffering
+	// disabled
+	SyncOnFlush Durability = 3
+)
+
+// SyncError is returned if data is written into hot file, but sync of the file failed.
+// Write() returns it along with number of bytes written, the data must not be written
+// again to avoid duplicates. Use errors.As() to tell it from write errors.
+type SyncError struct {
+	Err error
+}
+
+func (e *SyncError) Error() string {
+	return "logwriter: sync: " + e.Err.Error()
+}
+
+// Unwrap returns error of sync
+func (e *SyncError) Unwrap() error {
+	return e.Err
+}
+
+// syncDir flushes folder entries (created, renamed files) to disk
+func syncDir(fs FS, dir string) error {
+	if dir == "" {
+		dir = "."
+	}
+	return fs.SyncDir(dir)
+}
+
+// syncDirs syncs folders of names. Each folder synced once
+func syncDirs(fs FS, names ...string) error {
+
+	synced := make(map[string]bool, len(names))
+
+	for _, name := range names {
+		dir := filepath.Dir(name)
+		if synced[dir] {
+			continue
+		}
+		synced[dir] = true
+
+		if err := syncDir(fs, dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// written registers n bytes written into hot file and syncs it if required by
+// config.Durability. Must be called with lw locked.
+func (lw *LogWriter) written(n int) error {
+	if lw.syncRequired(n) {
+		return lw.syncHot()
+	}
+	return nil
+}
+
+// syncRequired registers n bytes written into hot file and returns true if hot file
+// has to be synced. Must be called with lw locked.
+func (lw *LogWriter) syncRequired(n int) bool {
+
+	lw.unsynced += int64(n)
+
+	switch lw.config.Durability {
+	case SyncOnFlush:
+	case SyncPeriodically:
+		if lw.config.SyncEveryBytes <= 0 || lw.unsynced < lw.config.SyncEveryBytes {
+			return false
+		}
+	default:
+		return false
+	}
+
+	lw.unsynced = 0
+	return true
+}
+
+// syncHot syncs hot file. Must be called with lw locked.
+func (lw *LogWriter) syncHot() error {
+
+	lw.collectFlusher(true)
+
+	lw.unsynced = 0
+	if err := lw.f.Sync(); err != nil {
+		return &SyncError{Err: err}
+	}
+	return nil
+}
+
+// syncByTimer syncs hot file if something written since previous sync
+func (lw *LogWriter) syncByTimer() {
+
+	lw.Lock()
+
+	if lw.unsynced > 0 {
+		if err := lw.syncHot(); err != nil && lw.errHandler != nil {
+			lw.errHandler(err)
+		}
+	}
+
+	lw.Unlock()
+}
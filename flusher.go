@@ -0,0 +1,200 @@
+package logwriter
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// flusher persists full buffers in background, so Write() swaps buffers instead of
+// waiting for disk i/o. See Config.BufferCount.
+type flusher struct {
+	queue chan flushJob
+
+	// buffers available for swapping
+	free chan []byte
+
+	// in-flight jobs
+	pending sync.WaitGroup
+
+	// closed when run() exits
+	done chan struct{}
+
+	mu sync.Mutex
+
+	// first error happened since previous takeErr() call
+	err error
+
+	// bytes and lines written since previous collect() call
+	written, lines int64
+
+	// data of failed write and of all buffers queued after it, kept in order until
+	// takeFailed() call. Nothing is written while there is failed data
+	failed      []byte
+	failedLines int64
+
+	// error handler, see LogWriter.SetErrorFunc()
+	errf func(error)
+
+	// size of buffers returned by swap(), written buffers are reallocated if required
+	size int
+}
+
+type flushJob struct {
+	w   io.Writer
+	buf []byte
+
+	// number of lines in buf
+	lines int64
+
+	// file to be synced after write if not nil
+	sync File
+}
+
+// newFlusher starts flusher having count-1 spare buffers of size bytes
+func newFlusher(count, size int, errf func(error)) *flusher {
+
+	f := &flusher{
+		queue: make(chan flushJob, count),
+		free:  make(chan []byte, count),
+		done:  make(chan struct{}),
+		errf:  errf,
+		size:  size}
+
+	for i := 1; i < count; i++ {
+		f.free <- make([]byte, size)
+	}
+
+	go f.run()
+
+	return f
+}
+
+func (f *flusher) run() {
+
+	for job := range f.queue {
+
+		f.mu.Lock()
+		failing := len(f.failed) > 0
+		f.mu.Unlock()
+
+		var (
+			n   int
+			err error
+		)
+
+		if !failing {
+			n, err = job.w.Write(job.buf)
+			if err == nil && job.sync != nil {
+				// data is written anyway
+				if err = job.sync.Sync(); err != nil {
+					err = &SyncError{Err: err}
+				}
+			}
+		}
+
+		f.mu.Lock()
+		lines := job.lines
+		if n < len(job.buf) {
+			// keep the rest to be written again by LogWriter
+			rest := job.buf[n:]
+			f.failed = append(f.failed, rest...)
+			if lines > 0 {
+				// lines are counted if config.HotMaxLines > 0
+				left := int64(bytes.Count(rest, newLine))
+				f.failedLines += left
+				lines -= left
+			}
+		}
+		f.written += int64(n)
+		f.lines += lines
+
+		errf, size := f.errf, f.size
+		if err != nil && f.err == nil {
+			f.err = err
+		}
+		f.mu.Unlock()
+
+		if err != nil && errf != nil {
+			errf(err)
+		}
+
+		// adaptive buffer resized meanwhile, allocate here rather than in Write()
+		buf := job.buf[:cap(job.buf)]
+		if !fits(buf, size) {
+			buf = make([]byte, size)
+		}
+
+		f.free <- buf
+		f.pending.Done()
+	}
+
+	close(f.done)
+}
+
+// swap queues buf having lines to be written into w and returns empty buffer.
+// Blocks if all buffers are in-flight. File sync is synced after write if not nil.
+func (f *flusher) swap(w io.Writer, buf []byte, lines int64, sync File) []byte {
+	f.pending.Add(1)
+	f.queue <- flushJob{w: w, buf: buf, lines: lines, sync: sync}
+	return <-f.free
+}
+
+// wait blocks until all queued buffers are written
+func (f *flusher) wait() {
+	f.pending.Wait()
+}
+
+// collect returns and resets number of bytes and lines written
+func (f *flusher) collect() (n, lines int64) {
+	f.mu.Lock()
+	n, lines = f.written, f.lines
+	f.written, f.lines = 0, 0
+	f.mu.Unlock()
+	return n, lines
+}
+
+// failing returns true if there is data of failed writes
+func (f *flusher) failing() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.failed) > 0
+}
+
+// takeFailed returns data of failed writes and resumes writing. Call it after wait(),
+// so buffers queued after failure are returned too
+func (f *flusher) takeFailed() (data []byte, lines int64) {
+	f.mu.Lock()
+	data, lines = f.failed, f.failedLines
+	f.failed, f.failedLines = nil, 0
+	f.mu.Unlock()
+	return data, lines
+}
+
+// takeErr returns and resets background error
+func (f *flusher) takeErr() error {
+	f.mu.Lock()
+	err := f.err
+	f.err = nil
+	f.mu.Unlock()
+	return err
+}
+
+// resize sets size of buffers returned by swap()
+func (f *flusher) resize(size int) {
+	f.mu.Lock()
+	f.size = size
+	f.mu.Unlock()
+}
+
+func (f *flusher) setErrFunc(errf func(error)) {
+	f.mu.Lock()
+	f.errf = errf
+	f.mu.Unlock()
+}
+
+// stop writes queued buffers and stops background routine
+func (f *flusher) stop() {
+	close(f.queue)
+	<-f.done
+}
@@ -0,0 +1,136 @@
+package logwriter
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FS is a file system where LogWriter keeps hot and cold files. It lists operations
+// the package uses, so it is possible to run LogWriter against in-memory file system
+// or plug in specialized one (encrypted, quota limited, etc.).
+type FS interface {
+	// OpenFile opens named file with specified flag (os.O_RDONLY etc.) and perm
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+
+	// Rename renames (moves) oldname to newname
+	Rename(oldname, newname string) error
+
+	// Remove removes named file or empty folder
+	Remove(name string) error
+
+	// Stat returns os.FileInfo describing named file
+	Stat(name string) (os.FileInfo, error)
+
+	// ReadDir returns directory entries sorted by file name
+	ReadDir(name string) ([]os.FileInfo, error)
+
+	// SyncDir commits folder entries (created, renamed files) to stable storage
+	SyncDir(name string) error
+
+	// MkdirAll creates folder name along with missing parents
+	MkdirAll(name string, perm os.FileMode) error
+}
+
+// File is an open file of FS. *os.File implements it.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+
+	// Name returns the name of the file as presented to OpenFile
+	Name() string
+
+	// Stat returns os.FileInfo describing file
+	Stat() (os.FileInfo, error)
+
+	// Sync commits file content to stable storage
+	Sync() error
+}
+
+// OSFS is FS backed by package os. It is used if Config.FS is nil.
+var OSFS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		// prevent non-nil interface holding nil *os.File
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) Rename(oldname, newname string) error {
+	return os.Rename(oldname, newname)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) ReadDir(name string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(name)
+}
+
+func (osFS) SyncDir(name string) error {
+
+	d, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+
+	if err = d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+
+	return d.Close()
+}
+
+func (osFS) MkdirAll(name string, perm os.FileMode) error {
+	return os.MkdirAll(name, perm)
+}
+
+// mkdirAll creates folder dir along with missing parents. Folders holding created
+// ones are synced if durable
+func mkdirAll(fs FS, dir string, perm os.FileMode, durable bool) error {
+
+	if fileExists(fs, dir) {
+		return nil
+	}
+
+	// missing folders, the topmost first
+	created := []string{dir}
+	for p := filepath.Dir(dir); p != filepath.Dir(p) && !fileExists(fs, p); p = filepath.Dir(p) {
+		created = append([]string{p}, created...)
+	}
+
+	if err := fs.MkdirAll(dir, perm); err != nil {
+		return err
+	}
+
+	if durable {
+		return syncDirs(fs, created...)
+	}
+
+	return nil
+}
+
+func fileExists(fs FS, name string) bool {
+	_, err := fs.Stat(name)
+	return err == nil
+}
+
+func fsOrDefault(fs FS) FS {
+	if fs == nil {
+		return OSFS
+	}
+	return fs
+}
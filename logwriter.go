@@ -9,11 +9,12 @@
 package logwriter
 
 import (
+	"bytes"
 	"compress/gzip"
-	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sync"
 	"time"
 )
@@ -30,8 +31,11 @@ var (
 	// CompressedColdFileExtension holds extension for compressed 'cold' files.
 	CompressedColdFileExtension = "tz"
 
-	// TraceFileExtension holds extension for trace files. (Not implemented yet)
+	// TraceFileExtension holds extension for hot and cold trace files, see Config.Trace.
 	TraceFileExtension = "trc"
+
+	// DumpFileExtension holds extension for files of recent log items, see LogWriter.DumpRecentFile().
+	DumpFileExtension = "dump"
 )
 
 // RunningMode represents application running mode
@@ -61,26 +65,137 @@ type Config struct {
 	// Output buffer size. Buffering disabled if value == 0
 	BufferSize int
 
+	// Number of buffers of BufferSize (works if BufferSize > 0). If value > 1, full buffer
+	// is written in background while Write() continues with the next one. Background
+	// write errors are passed to error handler and returned by FlushBuffer() and Close(),
+	// data not written is kept and written again before the next buffer
+	BufferCount int
+
 	// Flush buffer to disk every BufferFlushInterval (works if BufferSize > 0)
 	BufferFlushInterval time.Duration
 
+	// Enables adaptive buffer sizing if greater than BufferSize. Buffer grows up to
+	// BufferMaxSize and shrinks down to BufferSize, so at current write rate it is
+	// flushed about every BufferFlushTarget
+	BufferMaxSize int
+
+	// Desired interval between buffer flushes in adaptive mode. Default value is 1 second
+	BufferFlushTarget time.Duration
+
+	// Records defines log record boundaries. Buffer flushes and hot file freezes never split a record
+	Records RecordMode
+
+	// Continuation matches lines continuing previous one if Records == GroupRecords.
+	// Lines starting with space or tab are continuation if nil
+	Continuation *regexp.Regexp
+
+	// Urgent detects log items (errors, fatal messages) which must be persisted
+	// together with buffered ones before Write() returns. See WriteUrgent()
+	Urgent func(p []byte) bool
+
+	// Trace enables trace file "uid.trc" having own size limit, freeze and compression
+	// rules. Empty HotPath, ColdPath, name templates, FS and Clock are taken from parent
+	// config, Mode is always the parent one. Trace.Trace is ignored
+	Trace *Config
+
+	// TraceMatch diverts log items passed to Write() into trace file if Trace != nil.
+	// See WriteTrace()
+	TraceMatch func(p []byte) bool
+
+	// Keep RecentItems last log items in memory, see LogWriter.Recent()
+	RecentItems int
+
+	// Keep last log items of RecentSize bytes in total in memory
+	RecentSize int
+
+	// RecentOnly selects log items (debug records) kept in memory only, so they are
+	// not written into hot file unless dumped. Works if RecentItems or RecentSize > 0
+	RecentOnly func(p []byte) bool
+
+	// CaptureTrigger enables flight recorder. Log item matching it (error) makes recent
+	// log items kept in memory and CaptureAfter following items to be written into
+	// trace file. Works if Trace != nil and RecentItems or RecentSize > 0
+	CaptureTrigger func(p []byte) bool
+
+	// Number of log items following CaptureTrigger match written into trace file
+	CaptureAfter int
+
+	// ReorderWindow holds log items for the window and writes them ordered by
+	// timestamp, so items of concurrent goroutines are persisted in time order.
+	// Held items are written before hot file freezes. Disabled if 0
+	ReorderWindow time.Duration
+
+	// Timestamp extracts time of log item for reordering. ParseTimestamp is used if nil.
+	// Items without timestamp follow the previous one
+	Timestamp func(p []byte) (time.Time, bool)
+
 	// Freeze hot file when size reaches HotMaxSize (value in bytes)
 	HotMaxSize int64
 
+	// Preallocate disk space of HotMaxSize for hot file to reduce fragmentation
+	// and write latency. Linux only, ignored if HotMaxSize == 0 or FS is not OSFS.
+	// File size is not changed, unused space is released on freeze and Close()
+	Preallocate bool
+
+	// Freeze hot file when it contains HotMaxLines newline terminated lines (if value > 0).
+	// Single Write() is never split, so cold file gets all lines of the Write reached the limit
+	HotMaxLines int64
+
 	// Freeze hot file every FreezeInterval if value > 0
 	FreezeInterval time.Duration
 
 	// Freeze hot file at midnight
 	FreezeAtMidnight bool
 
+	// Freeze non-empty hot file if there were no writes during FreezeAfterIdle (if value > 0)
+	FreezeAfterIdle time.Duration
+
 	// Folder where to open/create hot log file
 	HotPath string
 
-	// Folder where to copy cold file (frozen hot file)
+	// Folder where to copy cold file (frozen hot file). It may be a template having
+	// date tokens, e.g. "/var/log/svc/{yyyy}/{mm}/{dd}", folders are created on demand.
+	// See NameTemplate
 	ColdPath string
 
+	// Permissions of folders created in ColdPath. Default value is 0755
+	ColdDirMode os.FileMode
+
+	// Remove cold files older than ColdMaxAge after freeze, then folders of ColdPath
+	// template left empty. File age is taken from {end} time of file name or from
+	// modification time. Files of other uids are kept. Expired files are looked for
+	// at most every tenth of ColdMaxAge, but at least hourly. Disabled if 0
+	ColdMaxAge time.Duration
+
 	// CompressColdFile compresses cold file
 	CompressColdFile bool
+
+	// HotNameTemplate defines hot file name, DefaultHotNameTemplate is used if empty.
+	// See NameTemplate. Tokens changing between runs ({pid}, {seq} and times) are not
+	// allowed, hot file of previous run is found by name. New template applies to hot
+	// file created after freeze
+	HotNameTemplate string
+
+	// ColdNameTemplate defines cold file name, DefaultColdNameTemplate is used if empty.
+	// See NameTemplate.
+	// ContentTimeColdNameTemplate names files by time range of their log items
+	ColdNameTemplate string
+
+	// Clock drives timers and cold file time stamps. SystemClock is used if nil
+	Clock Clock
+
+	// Durability defines when hot file is synced to disk. DurabilityNone by default
+	Durability Durability
+
+	// Sync hot file every SyncEveryBytes written if Durability == SyncPeriodically and value > 0
+	SyncEveryBytes int64
+
+	// Sync hot file every SyncInterval if Durability == SyncPeriodically and value > 0
+	SyncInterval time.Duration
+
+	// FS holds hot and cold files. OSFS is used if nil. Applied by NewLogWriter() only,
+	// SetConfig() keeps file system LogWriter was created with
+	FS FS
 }
 
 // LogWriter wraps io.Writer to automate routine with log files.
@@ -93,21 +208,51 @@ type LogWriter struct {
 	// instance configration
 	config Config
 
-	// buffer with capacity config.BufferSize
+	// buffer of config.BufferSize, or of adaptive size if config.BufferMaxSize > config.BufferSize
 	buffer []byte
 
+	// time of the last buffer flush, see adaptBuffer()
+	lastFlush time.Time
+
 	// buffer allocated
 	bufferLen int
 
+	// background buffer writer, not nil if config.BufferCount > 1
+	flusher *flusher
+
 	// hot and cold file name prefix
 	uid string
 
 	// hot file handle
-	f *os.File
+	f File
 
 	// hot file current size
 	filelen int64
 
+	// bytes written into hot file since last sync
+	unsynced int64
+
+	// number of lines in hot file and in buffer. Tracked if config.HotMaxLines > 0
+	fileLines   int64
+	bufferLines int64
+
+	// bytes and lines passed to flusher, but not written yet
+	flushing      int64
+	flushingLines int64
+
+	// data of failed background writes to be written before buffer, see flushUnflushed()
+	unflushed      []byte
+	unflushedLines int64
+
+	// time of the last Write() call. Tracked if config.FreezeAfterIdle > 0
+	lastWrite time.Time
+
+	// incomplete record waiting for its end, see config.Records
+	partial []byte
+
+	// there were no writes since previous buffer flush timer tick
+	partialIdle bool
+
 	// function to sync call in case of i/o error
 	errHandler func(error)
 
@@ -120,52 +265,136 @@ type LogWriter struct {
 	// error raised in background
 	err error
 
-	// reference to func
+	// reference to func set by SetColdNameFormatter(). coldTemplate is used if nil
 	coldFileNameFormatter func(string, string, time.Duration) string
 
+	// source of time, taken from config.Clock
+	clock Clock
+
+	// file system, taken from config.FS
+	fs FS
+
 	// save public variable HotFileExtension to prevent racing
 	hotFileExtension string
 
 	// save public variable CotFileExtension to prevent racing
 	coldFileExtension string
+
+	// trace file writer, not nil if config.Trace != nil
+	trace *LogWriter
+
+	// recent log items, not nil if config.RecentItems or config.RecentSize > 0
+	recent *ring
+
+	// number of log items to be captured into trace file, see config.CaptureTrigger
+	captureLeft int
+
+	// log items held by reorder window, not nil if config.ReorderWindow > 0
+	reorder *reorderQueue
+
+	// true while held items are written, hot file freeze is postponed
+	reordering bool
+
+	// parsed config.HotNameTemplate and config.ColdNameTemplate, defaults are parsed
+	// if empty
+	hotTemplate  *NameTemplate
+	coldTemplate *NameTemplate
+
+	// parsed config.ColdPath
+	coldDirTemplate *NameTemplate
+
+	// closed when the last cold file move and removal of expired ones are done. Moves
+	// run one by one in freeze order, so created folder is not removed before cold
+	// file gets there and older cold files do not appear after removal
+	coldDone chan struct{}
+
+	// time cold files were expired last time, see config.ColdMaxAge
+	coldExpired time.Time
+
+	// value of {hostname} token
+	hostname string
+
+	// time hot file was created, value of {start} token
+	hotStarted time.Time
+
+	// times of the first and the last writes into hot file, values of {first} and {last}
+	// tokens. Tracked if cold file name template has them
+	hotFirst    time.Time
+	hotLast     time.Time
+	trackWrites bool
+
+	// last cold file name given by template or formatter and its sequence number, see coldName()
+	coldBase string
+	coldSeq  int
 }
 
 // NewLogWriter creates new LogWriter, opens/creates hot file "%uid%.log". Hot file
 // freezes immediately if freezeExisting is true and non-empty file size > 0.
 func NewLogWriter(uid string, cfg *Config, freezeExisting bool, errHanldler func(error)) (*LogWriter, error) {
+	return newLogWriter(uid, cfg, freezeExisting, errHanldler, HotFileExtension, ColdFileExtension)
+}
+
+func newLogWriter(uid string, cfg *Config, freezeExisting bool, errHanldler func(error), hotExt, coldExt string) (*LogWriter, error) {
 
 	lw := &LogWriter{
-		uid:                   uid,
-		RWMutex:               sync.RWMutex{},
-		waitGroup:             &sync.WaitGroup{},
-		stopTimersSignal:      make(chan bool),
-		done:                  make(chan bool),
-		errHandler:            errHanldler,
-		coldFileNameFormatter: defaultColdNameFormatter,
-		hotFileExtension:      HotFileExtension,
-		coldFileExtension:     ColdFileExtension}
+		uid:               uid,
+		RWMutex:           sync.RWMutex{},
+		waitGroup:         &sync.WaitGroup{},
+		stopTimersSignal:  make(chan bool),
+		done:              make(chan bool),
+		errHandler:        errHanldler,
+		hotFileExtension:  hotExt,
+		coldFileExtension: coldExt}
 
 	if cfg != nil {
 		lw.config = *cfg
 	}
 
+	lw.clock = clockOrDefault(lw.config.Clock)
+	lw.fs = fsOrDefault(lw.config.FS)
+	lw.lastWrite = lw.clock.Now()
+	lw.lastFlush = lw.lastWrite
+	lw.setRecent()
+	lw.setReorder()
+
+	if err := lw.setNameTemplates(); err != nil {
+		return nil, err
+	}
+
 	if lw.config.BufferSize > 0 {
 		lw.buffer = make([]byte, cfg.BufferSize)
 
-		// Not allow to have cold file size more than specified. Because buffer flushes when it's full
-		if lw.config.HotMaxSize > 0 && (lw.config.HotMaxSize-int64(lw.config.BufferSize) > 0) {
-			lw.config.HotMaxSize -= int64(lw.config.BufferSize)
-
+		// Not allow to have cold file size more than specified. Because buffer flushes when it's full,
+		// adaptive buffer grows up to BufferMaxSize
+		reserve := int64(lw.config.BufferSize)
+		if lw.config.BufferMaxSize > lw.config.BufferSize {
+			reserve = int64(lw.config.BufferMaxSize)
+		}
+		if lw.config.HotMaxSize > 0 && (lw.config.HotMaxSize-reserve > 0) {
+			lw.config.HotMaxSize -= reserve
 		}
+
+		lw.startFlusher()
 	}
 
 	if err := lw.initHotFile(); err != nil {
+		lw.stopFlusher()
 		return nil, err
 	}
 
 	if freezeExisting && lw.filelen > 0 {
 		// non-empty hot log file found and must be frozen
 		if err := lw.freeze(false); err != nil {
+			lw.stopFlusher()
+			return nil, err
+		}
+	}
+
+	if lw.config.Trace != nil {
+		var err error
+		if lw.trace, err = newTraceWriter(uid, &lw.config, freezeExisting, errHanldler); err != nil {
+			_ = lw.close()
+			lw.stopFlusher()
 			return nil, err
 		}
 	}
@@ -175,9 +404,11 @@ func NewLogWriter(uid string, cfg *Config, freezeExisting bool, errHanldler func
 	return lw, nil
 }
 
-// SetColdNameFormatter replaces default 'cold' file name generator.
-// Default format is "$uid-20060102-150405[.00000].log" implemented by
-// function defaultColdNameFormatter().
+// SetColdNameFormatter replaces 'cold' file name generator, it overrides Config.ColdNameTemplate.
+// Passing nil restores the template. Sequence suffix "-N" is added to names already taken,
+// so cold file is never overwritten.
+//
+// Deprecated: use Config.ColdNameTemplate.
 func (lw *LogWriter) SetColdNameFormatter(f func(string, string, time.Duration) string) {
 	lw.Lock()
 	lw.coldFileNameFormatter = f
@@ -191,6 +422,12 @@ func (lw *LogWriter) SetColdNameFormatter(f func(string, string, time.Duration)
 func (lw *LogWriter) SetErrorFunc(f func(error)) {
 	lw.Lock()
 	lw.errHandler = f
+	if lw.flusher != nil {
+		lw.flusher.setErrFunc(f)
+	}
+	if lw.trace != nil {
+		lw.trace.SetErrorFunc(f)
+	}
 	lw.Unlock()
 	return
 }
@@ -205,14 +442,41 @@ func (lw *LogWriter) Close() error {
 
 	lw.Lock()
 	err := lw.close()
+	lw.stopFlusher()
+	trace := lw.trace
 	lw.Unlock()
+
+	if trace != nil {
+		if terr := trace.Close(); err == nil {
+			err = terr
+		}
+	}
+
 	return err
 }
 
 func (lw *LogWriter) close() error {
+	if err := lw.emitReordered(true); err != nil {
+		return err
+	}
+	if err := lw.flushPartial(); err != nil {
+		return err
+	}
 	if err := lw.flush(false); err != nil {
 		return err
 	}
+	if err := lw.waitFlusher(); err != nil {
+		// the last attempt to write data of failed background writes
+		_ = lw.flushUnflushed()
+		_ = lw.f.Close()
+		return err
+	}
+	if lw.config.Durability != DurabilityNone {
+		if err := lw.syncHot(); err != nil {
+			_ = lw.f.Close()
+			return err
+		}
+	}
 	return lw.f.Close()
 }
 
@@ -220,48 +484,97 @@ func (lw *LogWriter) close() error {
 // applies new Config, recreate buffer if need, starts timers.
 func (lw *LogWriter) SetConfig(cfg *Config) error {
 
+	if _, _, _, err := nameTemplates(cfg); err != nil {
+		return err
+	}
+
 	lw.stopTimers()
 
 	lw.Lock()
 
+	if err := lw.emitReordered(true); err != nil {
+		lw.startTimers()
+		lw.Unlock()
+		return err
+	}
+
+	// incomplete record is not kept if record mode changes
+	if cfg == nil || cfg.Records != lw.config.Records {
+		if err := lw.flushPartial(); err != nil {
+			lw.startTimers()
+			lw.Unlock()
+			return err
+		}
+	}
+
 	if err := lw.flush(false); err != nil {
 		lw.startTimers()
 		lw.Unlock()
 		return err
 	}
 
+	// errors of buffers in-flight are not lost with flusher
+	if err := lw.waitFlusher(); err != nil {
+		lw.startTimers()
+		lw.Unlock()
+		return err
+	}
+
 	if cfg == nil {
 		lw.setConfig(&Config{})
 	} else {
 		lw.setConfig(cfg)
 	}
 
+	err := lw.setTrace()
+
 	lw.startTimers()
 	lw.Unlock()
 
-	return nil
+	return err
 }
 
 func (lw *LogWriter) setConfig(cfg *Config) {
 
 	oldMode := lw.config.Mode
 	oldBufferSize := lw.config.BufferSize
+	oldBufferCount := lw.config.BufferCount
+	oldHotMaxLines := lw.config.HotMaxLines
 
 	lw.config = *cfg
+	lw.config.FS = lw.fs
+	lw.clock = clockOrDefault(cfg.Clock)
+	lw.lastWrite = lw.clock.Now()
+	lw.lastFlush = lw.lastWrite
+	lw.setRecent()
+	lw.setReorder()
+	_ = lw.setNameTemplates() // validated by SetConfig()
 
 	if oldMode != cfg.Mode {
 		lw.setMode(cfg.Mode)
 	}
 
 	// recreate buffer if required
-	if oldBufferSize != cfg.BufferSize {
+	if oldBufferSize != cfg.BufferSize || oldBufferCount != cfg.BufferCount {
+		lw.stopFlusher()
+
 		if cfg.BufferSize > 0 {
 			lw.buffer = make([]byte, cfg.BufferSize)
+			lw.startFlusher()
 		} else {
 			lw.buffer = nil
 		}
 	}
 
+	// lines are not counted while limit is disabled. Buffer is flushed already
+	if oldHotMaxLines == 0 && cfg.HotMaxLines > 0 {
+		lines, err := lw.countHotLines()
+		if err != nil && lw.errHandler != nil {
+			lw.errHandler(err)
+		}
+		lw.fileLines, lw.bufferLines = lines, 0
+	}
+
 	return
 }
 
@@ -305,19 +618,75 @@ func (lw *LogWriter) FlushBuffer() error {
 
 func (lw *LogWriter) flushBuffer(byTimer bool) error {
 	lw.Lock()
-	err := lw.flush(byTimer)
-	lw.Unlock()
-	return err
+	defer lw.Unlock()
+
+	if byTimer {
+		// group is complete if nothing was written during whole timer interval
+		if lw.partialIdle {
+			if err := lw.flushGroup(); err != nil && lw.errHandler != nil {
+				lw.errHandler(err)
+			}
+		}
+		lw.partialIdle = true
+	}
+
+	if err := lw.flush(byTimer); err != nil || byTimer {
+		return err
+	}
+
+	// buffer is persisted when background write completes
+	return lw.waitFlusher()
 }
 
 func (lw *LogWriter) flush(byTimer bool) error {
 
+	// data of failed background writes goes first
+	if err := lw.flushUnflushed(); err != nil {
+		if byTimer && lw.errHandler != nil {
+			lw.errHandler(err)
+			return nil
+		}
+		return err
+	}
+
 	if lw.config.BufferSize == 0 || lw.bufferLen == 0 {
 		return nil
 	}
 
+	n := lw.bufferLen
+	size := len(lw.buffer)
+	started := lw.clock.Now()
+
+	if lw.flusher != nil {
+		// swap buffers, flusher reports errors itself
+		var sync File
+		if lw.syncRequired(n) {
+			sync = lw.f
+		}
+		lw.buffer = lw.flusher.swap(lw.w, lw.buffer[:n], lw.bufferLines, sync)
+
+		// buffers in-flight could be of another size in adaptive mode
+		lw.resizeBuffer(size)
+
+		// counted as written by collectFlusher()
+		lw.flushing += int64(n)
+		lw.flushingLines += lw.bufferLines
+		lw.bufferLen = 0
+		lw.bufferLines = 0
+		lw.collectFlusher(false)
+
+		lw.adaptBuffer(n, started)
+		return nil
+	}
+
 	n, err := lw.w.Write(lw.buffer[:lw.bufferLen])
 
+	// written part of buffer is not written again, even if sync fails
+	lw.dropBuffered(n)
+	if err == nil {
+		err = lw.written(n)
+	}
+
 	if err != nil {
 		if byTimer && lw.errHandler != nil {
 			lw.errHandler(err)
@@ -326,36 +695,207 @@ func (lw *LogWriter) flush(byTimer bool) error {
 		return err
 	}
 
-	lw.filelen += int64(n)
-	lw.bufferLen = 0
+	lw.adaptBuffer(n, started)
 
 	return nil
 }
 
-// runner triggers time based actions
-func (lw *LogWriter) runner(cfg Config) {
+// dropBuffered counts the first n bytes of buffer written into hot file, the rest
+// is moved to the beginning of buffer. Must be called with lw locked.
+func (lw *LogWriter) dropBuffered(n int) {
+
+	lw.filelen += int64(n)
+
+	if n == lw.bufferLen {
+		lw.bufferLen = 0
+		lw.fileLines += lw.bufferLines
+		lw.bufferLines = 0
+		return
+	}
 
-	bufferFlushTimer := time.NewTimer(cfg.BufferFlushInterval)
-	midnightTimer := time.NewTimer(time.Second)
-	fileFreezeTimer := time.NewTimer(cfg.FreezeInterval)
+	if lw.bufferLines > 0 {
+		lines := int64(bytes.Count(lw.buffer[:n], newLine))
+		lw.fileLines += lines
+		lw.bufferLines -= lines
+	}
+	lw.bufferLen = copy(lw.buffer, lw.buffer[n:lw.bufferLen])
+}
 
-	// All non required Timers are stopped. It allows to use single select{} operator
+// startFlusher starts background buffer writer if config requires
+func (lw *LogWriter) startFlusher() {
+	if lw.config.BufferSize > 0 && lw.config.BufferCount > 1 {
+		lw.flusher = newFlusher(lw.config.BufferCount, lw.config.BufferSize, lw.errHandler)
+	}
+}
+
+// stopFlusher writes buffers in-flight and stops background buffer writer. Data of
+// failed writes is kept to be written by flushUnflushed()
+func (lw *LogWriter) stopFlusher() {
+	if lw.flusher != nil {
+		lw.collectFlusher(true)
+		lw.flusher.stop()
+		lw.flusher = nil
+	}
+}
+
+// waitFlusher waits for buffers in-flight and returns background write error if any
+func (lw *LogWriter) waitFlusher() error {
+	if lw.flusher == nil {
+		return nil
+	}
+	lw.collectFlusher(true)
+	return lw.flusher.takeErr()
+}
+
+// collectFlusher counts data written by flusher into hot file. If wait is true, it waits
+// for buffers in-flight and takes data of failed writes into lw.unflushed.
+func (lw *LogWriter) collectFlusher(wait bool) {
+
+	if lw.flusher == nil {
+		return
+	}
+
+	if wait {
+		lw.flusher.wait()
+	}
+
+	n, lines := lw.flusher.collect()
+	lw.filelen += n
+	lw.fileLines += lines
+	lw.flushing -= n
+	lw.flushingLines -= lines
+
+	if !wait {
+		return
+	}
+
+	if data, lines := lw.flusher.takeFailed(); len(data) > 0 {
+		lw.unflushed = append(lw.unflushed, data...)
+		lw.unflushedLines += lines
+		lw.flushing -= int64(len(data))
+		lw.flushingLines -= lines
+	}
+}
+
+// flushUnflushed writes data of failed background writes into hot file, it must be
+// written before the buffer. Must be called with lw locked.
+func (lw *LogWriter) flushUnflushed() error {
+
+	if len(lw.unflushed) == 0 && (lw.flusher == nil || !lw.flusher.failing()) {
+		return nil
+	}
+
+	// buffers queued after failure are not written by flusher either
+	lw.collectFlusher(true)
+
+	n, err := lw.w.Write(lw.unflushed)
+	lw.dropUnflushed(n)
+
+	if err == nil {
+		err = lw.written(n)
+	}
+
+	return err
+}
+
+// dropUnflushed counts n bytes of lw.unflushed written into hot file
+func (lw *LogWriter) dropUnflushed(n int) {
+
+	if n == 0 {
+		return
+	}
+
+	lw.filelen += int64(n)
+
+	if n == len(lw.unflushed) {
+		lw.fileLines += lw.unflushedLines
+		lw.unflushed, lw.unflushedLines = nil, 0
+		return
+	}
+
+	if lw.unflushedLines > 0 {
+		lines := int64(bytes.Count(lw.unflushed[:n], newLine))
+		lw.fileLines += lines
+		lw.unflushedLines -= lines
+	}
+	lw.unflushed = append(lw.unflushed[:0], lw.unflushed[n:]...)
+}
+
+// hotLen returns size of hot file including data passed to flusher
+func (lw *LogWriter) hotLen() int64 {
+	return lw.filelen + lw.flushing + int64(len(lw.unflushed))
+}
+
+// runnerTimers holds timers served by runner()
+type runnerTimers struct {
+	bufferFlush Timer
+	midnight    Timer
+	fileFreeze  Timer
+	idleFreeze  Timer
+	sync        Timer
+	reorder     Timer
+
+	// time when timers were created
+	created time.Time
+}
+
+// newRunnerTimers creates timers for runner(). All non required timers are stopped.
+// Timers are created before runner() starts, so Clock knows them immediately.
+func newRunnerTimers(cfg Config, clock Clock) *runnerTimers {
+
+	now := clock.Now()
+
+	t := &runnerTimers{
+		bufferFlush: clock.NewTimer(cfg.BufferFlushInterval),
+		midnight:    clock.NewTimer(untilMidnight(now)),
+		fileFreeze:  clock.NewTimer(cfg.FreezeInterval),
+		idleFreeze:  clock.NewTimer(cfg.FreezeAfterIdle),
+		sync:        clock.NewTimer(cfg.SyncInterval),
+		reorder:     clock.NewTimer(cfg.ReorderWindow),
+		created:     now}
+
+	// It allows to use single select{} operator
 	// May be separate runners will be more efficient. Benchmarking required
 	if cfg.BufferFlushInterval == 0 {
-		bufferFlushTimer.Stop()
+		t.bufferFlush.Stop()
 	}
 
 	if !cfg.FreezeAtMidnight {
-		midnightTimer.Stop()
+		t.midnight.Stop()
 	}
 
 	if cfg.FreezeInterval == 0 {
-		fileFreezeTimer.Stop()
+		t.fileFreeze.Stop()
+	}
+
+	if cfg.FreezeAfterIdle == 0 {
+		t.idleFreeze.Stop()
+	}
+
+	if cfg.Durability != SyncPeriodically || cfg.SyncInterval == 0 {
+		t.sync.Stop()
+	}
+
+	if cfg.ReorderWindow == 0 {
+		t.reorder.Stop()
 	}
 
+	return t
+}
+
+// runner triggers time based actions
+func (lw *LogWriter) runner(cfg Config, clock Clock, t *runnerTimers) {
+
+	bufferFlushTimer := t.bufferFlush
+	midnightTimer := t.midnight
+	fileFreezeTimer := t.fileFreeze
+	idleFreezeTimer := t.idleFreeze
+	syncTimer := t.sync
+	reorderTimer := t.reorder
+
 	// variables required for midnight passing identification
 	// comparing date of last triggering with current
-	now := time.Now()
+	now := t.created
 	prev := now
 
 	for {
@@ -365,26 +905,29 @@ func (lw *LogWriter) runner(cfg Config) {
 			bufferFlushTimer.Stop()
 			fileFreezeTimer.Stop()
 			midnightTimer.Stop()
+			idleFreezeTimer.Stop()
+			syncTimer.Stop()
+			reorderTimer.Stop()
 			lw.done <- true
 			return
-		case _ = <-bufferFlushTimer.C:
+		case _ = <-bufferFlushTimer.C():
 			_ = lw.flushBuffer(true)
 
 			// Reset timer to compensate i/o time
 			_ = bufferFlushTimer.Reset(cfg.BufferFlushInterval)
 			break
-		case _ = <-fileFreezeTimer.C:
+		case _ = <-fileFreezeTimer.C():
 			_ = lw.freezeHotFile(true)
 
 			// Reset timer to compensate i/o time
 			_ = fileFreezeTimer.Reset(cfg.FreezeInterval)
 
-			if bufferFlushTimer != nil {
+			if cfg.BufferFlushInterval != 0 {
 				_ = bufferFlushTimer.Reset(cfg.BufferFlushInterval)
 			}
 
 			break
-		case now = <-midnightTimer.C:
+		case now = <-midnightTimer.C():
 			if prev.Day() != now.Day() {
 				prev = now
 
@@ -398,12 +941,66 @@ func (lw *LogWriter) runner(cfg Config) {
 					_ = bufferFlushTimer.Reset(cfg.BufferFlushInterval)
 				}
 			}
+
+			// timer fires once, so it has to be armed for the next midnight
+			_ = midnightTimer.Reset(untilMidnight(clock.Now()))
+			break
+		case _ = <-idleFreezeTimer.C():
+			// wait for the rest of idle period if there were writes
+			_ = idleFreezeTimer.Reset(lw.freezeIdle(cfg.FreezeAfterIdle))
+			break
+		case _ = <-syncTimer.C():
+			lw.syncByTimer()
+
+			_ = syncTimer.Reset(cfg.SyncInterval)
+			break
+		case _ = <-reorderTimer.C():
+			lw.emitByTimer()
+
+			_ = reorderTimer.Reset(cfg.ReorderWindow)
 			break
 
 		}
 	}
 }
 
+// freezeIdle freezes non-empty hot file if there were no writes during idle.
+// Returns duration after which the check has to be repeated.
+func (lw *LogWriter) freezeIdle(idle time.Duration) time.Duration {
+
+	lw.Lock()
+	defer lw.Unlock()
+
+	elapsed := lw.clock.Now().Sub(lw.lastWrite)
+	if elapsed < idle {
+		return idle - elapsed
+	}
+
+	if err := lw.flushGroup(); err != nil && lw.errHandler != nil {
+		lw.errHandler(err)
+	}
+
+	if lw.hotLen() == 0 && lw.bufferLen == 0 {
+		return idle
+	}
+
+	if err := lw.flush(true); err != nil {
+		return idle
+	}
+
+	if err := lw.freeze(true); err != nil && lw.errHandler != nil {
+		lw.errHandler(err)
+	}
+
+	return idle
+}
+
+// untilMidnight returns duration from t till the beginning of the next day
+func untilMidnight(t time.Time) time.Duration {
+	y, m, d := t.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location()).Sub(t)
+}
+
 // FreezeHotFile freezes hot file. Freeze steps: flush buffer, close file, rename hot file to
 // temporary file in the same folder, rename/move temp file to cold file (async), create new hot file.
 func (lw *LogWriter) FreezeHotFile() error {
@@ -432,12 +1029,41 @@ func (lw *LogWriter) freezeHotFile(byTimer bool) error {
 
 func (lw *LogWriter) freeze(byTimer bool) error {
 
+	if lw.reordering {
+		// freeze is repeated when held items are written
+		return nil
+	}
+
+	if lw.reorder != nil && lw.reorder.Len() > 0 {
+		// final pass, the whole window goes into the file being frozen
+		if err := lw.emitReordered(true); err != nil {
+			return err
+		}
+		if err := lw.flush(byTimer); err != nil {
+			return err
+		}
+	}
+
+	// background writes must complete before file closed. Errors already reported
+	// to errHandler and will be returned by FlushBuffer() or Close(), data of failed
+	// writes must be written before freeze
+	lw.collectFlusher(true)
+	if err := lw.flushUnflushed(); err != nil {
+		return err
+	}
+
 	if lw.filelen == 0 {
 		// nothing to do if file is empty
 		return nil
 	}
 
 	if lw.f != nil {
+		if lw.config.Durability != DurabilityNone {
+			if err := lw.syncHot(); err != nil {
+				return err
+			}
+		}
+
 		if err := lw.f.Close(); err != nil {
 			return err
 		}
@@ -445,44 +1071,118 @@ func (lw *LogWriter) freeze(byTimer bool) error {
 		return nil // TODO: Error
 	}
 
-	tempName := lw.coldFileNameFormatter(lw.uid, lw.coldFileExtension, lw.config.FreezeInterval)
-	tempFullName := filepath.Join(lw.config.HotPath, tempName)
+	names, seq := lw.coldName()
+	coldFullName, _ := names(seq)
+	tempFullName := filepath.Join(lw.config.HotPath, filepath.Base(coldFullName))
 
 	// rename hot file. Keep cold file in the same folder (it is faster)
-	if err := os.Rename(lw.f.Name(), tempFullName); err != nil {
+	if err := lw.fs.Rename(lw.f.Name(), tempFullName); err != nil {
+		// hot file is closed already. Reopen it to keep logging into the same file
+		if ierr := lw.initHotFile(); ierr != nil {
+			return ierr
+		}
 		return err
 	}
 
-	coldFullName := filepath.Join(lw.config.ColdPath, tempName)
+	lw.hotFirst, lw.hotLast = time.Time{}, time.Time{}
 
 	// move cold file into config.ColdPath (could be copy to another disk + delete)
 	// that's why another routine
+	durable := lw.config.Durability != DurabilityNone
+
+	dirMode := lw.config.ColdDirMode
+	if dirMode == 0 {
+		dirMode = 0755
+	}
+
+	compress := lw.config.CompressColdFile
+
+	var expire func()
+	if now := lw.clock.Now(); lw.config.ColdMaxAge > 0 && now.Sub(lw.coldExpired) >= expireInterval(lw.config.ColdMaxAge) {
+		lw.coldExpired = now
+		expire = lw.expireCold(now.Add(-lw.config.ColdMaxAge))
+	}
+
+	prev, done := lw.coldDone, make(chan struct{})
+	lw.coldDone = done
+
 	lw.waitGroup.Add(1)
-	go copyFile(tempFullName, coldFullName, CompressedColdFileExtension, lw.config.CompressColdFile, lw.errHandler, lw.waitGroup)
+	go func(fs FS, errf func(error), wg *sync.WaitGroup) {
+		defer wg.Done()
+		defer close(done)
+
+		if prev != nil {
+			<-prev
+		}
 
-	return lw.initHotFile()
+		copyFile(fs, tempFullName, names, seq, compress, durable, dirMode, errf)
+		if expire != nil {
+			expire()
+		}
+	}(lw.fs, lw.errHandler, lw.waitGroup)
+
+	if err := lw.initHotFile(); err != nil {
+		return err
+	}
+
+	if durable {
+		// hot file renamed and created again
+		return syncDir(lw.fs, lw.config.HotPath)
+	}
+
+	return nil
 }
 
-func copyFile(fromName, toName string, compressExt string, doCompress bool, errf func(error), wg *sync.WaitGroup) {
+// closeCompressed flushes gzip stream and closes zipFile, syncing it first if durable
+func closeCompressed(gzipWriter *gzip.Writer, zipFile File, durable bool) error {
+
+	err := gzipWriter.Close()
+	if err == nil && durable {
+		err = zipFile.Sync()
+	}
+
+	if err != nil {
+		_ = zipFile.Close()
+		return err
+	}
+
+	return zipFile.Close()
+}
+
+func copyFile(fs FS, fromName string, names coldNames, seq int, doCompress bool, durable bool, dirMode os.FileMode, errf func(error)) {
 
 	var (
-		zipFile, inputFile *os.File
+		zipFile, inputFile File
 		err                error
 	)
-	defer wg.Done()
+
+	// folder of cold file may be given by ColdPath template
+	if plain, _ := names(seq); filepath.Dir(plain) != filepath.Dir(fromName) {
+		if err = mkdirAll(fs, filepath.Dir(plain), dirMode, durable); err != nil {
+			if errf != nil {
+				errf(err)
+			}
+			return
+		}
+	}
 
 	if doCompress {
 
-		zipFileName := toName + "." + compressExt
+		var zipFileName string
 
 		for {
-			// create file with extension .zip
-			if zipFile, err = os.OpenFile(zipFileName, os.O_WRONLY|os.O_CREATE, 0600); err != nil {
+			// create file with extension .zip, never overwrite existing one
+			_, zipFileName = freeName(fs, fromName, names, seq)
+			if zipFile, err = fs.OpenFile(zipFileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600); err != nil {
+				if os.IsExist(err) {
+					// created by someone else after check
+					continue
+				}
 				break
 			}
 
 			// open file to be compress
-			if inputFile, err = os.OpenFile(fromName, os.O_RDONLY, 0); err != nil {
+			if inputFile, err = fs.OpenFile(fromName, os.O_RDONLY, 0); err != nil {
 				zipFile.Close()
 				break
 			}
@@ -496,19 +1196,30 @@ func copyFile(fromName, toName string, compressExt string, doCompress bool, errf
 
 			if err == nil {
 				// if no error during compression
-				if err = gzipWriter.Close(); err == nil {
-					if err = zipFile.Close(); err == nil {
-						if err = os.Remove(fromName); err == nil {
+				if err = closeCompressed(gzipWriter, zipFile, durable); err == nil {
+					if durable {
+						// compressed file must be on disk before source removed
+						err = syncDirs(fs, zipFileName)
+					}
+
+					if err == nil {
+						if err = fs.Remove(fromName); err == nil {
+							if durable {
+								// source is removed already, keep compressed file anyway
+								if err = syncDirs(fs, fromName); err != nil && errf != nil {
+									errf(err)
+								}
+							}
 							return
 						}
 					}
-				} else {
-					_ = zipFile.Close()
 				}
+			} else {
+				_ = zipFile.Close()
 			}
 
 			if err != nil {
-				_ = os.Remove(zipFileName)
+				_ = fs.Remove(zipFileName)
 			}
 			break
 		}
@@ -519,7 +1230,38 @@ func copyFile(fromName, toName string, compressExt string, doCompress bool, errf
 		return
 	}
 
-	err = os.Rename(fromName, toName)
+	// Rename silently replaces existing file, so name is reserved by empty file first
+	var toName string
+	for {
+		if toName, _ = freeName(fs, fromName, names, seq); toName == fromName {
+			break
+		}
+
+		var f File
+		if f, err = fs.OpenFile(toName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600); err != nil {
+			if os.IsExist(err) {
+				// created by someone else after check
+				continue
+			}
+			break
+		}
+
+		if err = f.Close(); err != nil {
+			_ = fs.Remove(toName)
+		}
+		break
+	}
+
+	if err == nil {
+		if err = fs.Rename(fromName, toName); err != nil && toName != fromName {
+			_ = fs.Remove(toName)
+		}
+	}
+
+	if err == nil && durable {
+		err = syncDirs(fs, toName, fromName)
+	}
+
 	if err != nil && errf != nil {
 		errf(err)
 	}
@@ -529,6 +1271,16 @@ func copyFile(fromName, toName string, compressExt string, doCompress bool, errf
 
 // Write 'overrides' the underlying io.Writer's Write method.
 func (lw *LogWriter) Write(p []byte) (n int, err error) {
+	return lw.writeItem(p, false)
+}
+
+// WriteUrgent writes p like Write() does, but flushes buffer before return, so
+// p and log items written before are not lost if application crashes.
+func (lw *LogWriter) WriteUrgent(p []byte) (n int, err error) {
+	return lw.writeItem(p, true)
+}
+
+func (lw *LogWriter) writeItem(p []byte, urgent bool) (n int, err error) {
 
 	lp := len(p)
 	if lp == 0 {
@@ -537,66 +1289,387 @@ func (lw *LogWriter) Write(p []byte) (n int, err error) {
 
 	lw.Lock()
 
+	if lw.keepRecent(p) {
+		lw.Unlock()
+		return lp, nil
+	}
+
+	if lw.traced(p) {
+		n, err = lw.trace.Write(p)
+		lw.Unlock()
+		return n, err
+	}
+
+	if lw.config.FreezeAfterIdle > 0 || lw.trackWrites {
+		lw.touch(lw.clock.Now())
+	}
+
+	n, err = lw.writeOne(p)
+
+	if err == nil && (urgent || (lw.config.Urgent != nil && lw.config.Urgent(p))) {
+		err = lw.flushUrgent()
+	}
+
+	lw.Unlock()
+	return n, err
+}
+
+// writeOne passes log item p into reorder window, if enabled, or writes it. Must
+// be called with lw locked.
+func (lw *LogWriter) writeOne(p []byte) (int, error) {
+
+	if lw.reorder != nil {
+		lw.hold(p)
+		return len(p), lw.emitReordered(false)
+	}
+
+	return lw.writeRecord(p)
+}
+
+// writeRecord passes log item p to record assembling or buffer. Must be called
+// with lw locked.
+func (lw *LogWriter) writeRecord(p []byte) (int, error) {
+	if lw.config.Records != WriteRecords {
+		return lw.writeRecords(p)
+	}
+	return lw.write(p)
+}
+
+// flushUrgent persists held log items, kept record group and buffer. Must be
+// called with lw locked.
+func (lw *LogWriter) flushUrgent() error {
+
+	if err := lw.emitReordered(true); err != nil {
+		return err
+	}
+
+	// urgent item completes the group
+	if err := lw.flushGroup(); err != nil {
+		return err
+	}
+
+	if err := lw.flush(false); err != nil {
+		return err
+	}
+
+	return lw.waitFlusher()
+}
+
+// write passes record p into the buffer or hot file and freezes hot file if
+// limits reached. Must be called with lw locked.
+func (lw *LogWriter) write(p []byte) (n int, err error) {
+
+	lp := len(p)
+
+	var lines int64
+	if lw.config.HotMaxLines > 0 {
+		lines = int64(bytes.Count(p, newLine))
+	}
+
 	if lw.config.BufferSize > 0 {
 
 		// if buffering enabled
-		if lp+lw.bufferLen < lw.config.BufferSize {
+		if lp+lw.bufferLen < len(lw.buffer) {
 			// there is space in the buffer to append
 			copy(lw.buffer[lw.bufferLen:], p)
 			lw.bufferLen += lp
-			lw.Unlock()
-			return lp, nil
-		}
+			lw.bufferLines += lines
 
-		// no space in the buffer buffer must be flushed first
-		n, err = lw.w.Write(lw.buffer[:lw.bufferLen])
-
-		if err == nil {
-			if lp < lw.config.BufferSize {
-				// if log item less then buffer size
-				// copy p[] to the beginning of buffer
-				lw.bufferLen = copy(lw.buffer[0:], p)
-			} else {
-				// []p bigger then buffer write directly to
-				n, err = lw.w.Write(p)
-				lw.bufferLen = 0
+			if lw.linesExceeded() {
+				err = lw.freezeByLines()
 			}
+
+			return lp, err
+		}
+
+		if lp >= len(lw.buffer) {
+			// []p bigger then buffer, write it together with buffer content
+			n, err = lw.writeThrough([][]byte{p}, lines)
 		} else {
-			// complaince with http://golang.org/pkg/io/#Writer
-			n = 0
+			// no space in the buffer buffer must be flushed first
+			if err = lw.flush(false); err != nil {
+				// complaince with http://golang.org/pkg/io/#Writer
+				return 0, err
+			}
+
+			// copy p[] to the beginning of buffer
+			lw.bufferLen = copy(lw.buffer[0:], p)
+			lw.bufferLines = lines
+			n = lp
 		}
 	} else {
 		// if no buffering
+		if err = lw.flushUnflushed(); err != nil {
+			return 0, err
+		}
+
 		n, err = lw.w.Write(p)
+		lw.filelen += int64(n)
+		lw.fileLines += lines
+		if err == nil {
+			err = lw.written(n)
+		}
 	}
 
 	if err != nil {
-		lw.Unlock()
 		return n, err
 	}
 
-	lw.filelen += int64(n)
+	return n, lw.checkLimits()
+}
 
-	if lw.config.HotMaxSize > 0 && (lw.config.HotMaxSize < lw.filelen) {
-		err = lw.freeze(false)
+// checkLimits freezes hot file if its size or number of lines exceeded.
+// Must be called with lw locked.
+func (lw *LogWriter) checkLimits() error {
+
+	if lw.reordering {
+		// checked when held items are written
+		return nil
+	}
+
+	if lw.config.HotMaxSize > 0 && (lw.config.HotMaxSize < lw.hotLen()) {
+		return lw.freeze(false)
+	}
+
+	if lw.linesExceeded() {
+		return lw.freezeByLines()
+	}
+
+	return nil
+}
+
+// writeThrough writes buffer content followed by bufs into hot file. Single
+// writev syscall is used if possible. It returns number of bytes of bufs
+// written. Must be called with lw locked.
+func (lw *LogWriter) writeThrough(bufs [][]byte, lines int64) (n int, err error) {
+
+	started := lw.clock.Now()
+
+	// after buffers in-flight
+	lw.collectFlusher(true)
+
+	ul := len(lw.unflushed)
+	bl := lw.bufferLen
+
+	vec := make([][]byte, 0, len(bufs)+2)
+	if ul > 0 {
+		vec = append(vec, lw.unflushed)
+	}
+	vec = append(vec, lw.buffer[:bl])
+	vec = append(vec, bufs...)
+
+	written, err := writeBuffers(lw.w, vec)
+
+	if written < int64(ul) {
+		lw.dropUnflushed(int(written))
+		return 0, err
+	}
+	lw.dropUnflushed(ul)
+	if ul > 0 && err == nil {
+		err = lw.written(ul)
+	}
+	written -= int64(ul)
+	lw.filelen += written
+
+	if written < int64(bl) {
+		// keep the rest of buffer
+		lw.bufferLen = copy(lw.buffer, lw.buffer[written:bl])
+		return 0, err
+	}
+
+	lw.fileLines += lw.bufferLines + lines
+	lw.bufferLen = 0
+	lw.bufferLines = 0
+
+	lw.adaptBuffer(int(written), started)
+
+	if err == nil {
+		err = lw.written(int(written))
+	}
+
+	return int(written) - bl, err
+}
+
+// writeBuffers writes several log items at once, see AsyncLogWriter. Items not
+// fitting into the buffer are written with single writev syscall.
+func (lw *LogWriter) writeBuffers(bufs [][]byte) (n int64, err error) {
+
+	lw.Lock()
+	defer lw.Unlock()
+
+	if lw.config.FreezeAfterIdle > 0 || lw.trackWrites {
+		lw.touch(lw.clock.Now())
+	}
+
+	var (
+		total  int
+		urgent bool
+	)
+
+	if lw.recent != nil {
+		// items kept in memory only are not written
+		kept := make([][]byte, 0, len(bufs))
+		for _, p := range bufs {
+			if !lw.keepRecent(p) {
+				kept = append(kept, p)
+			}
+		}
+		bufs = kept
+	}
+
+	for _, p := range bufs {
+		total += len(p)
+		if lw.config.Urgent != nil && lw.config.Urgent(p) {
+			urgent = true
+		}
+	}
+
+	if lw.config.Records == WriteRecords && lw.bufferLen+total >= len(lw.buffer) &&
+		lw.config.TraceMatch == nil && lw.reorder == nil {
+		n, err = lw.writeSegments(bufs)
+	} else {
+		for _, p := range bufs {
+			var k int
+			if lw.traced(p) {
+				k, err = lw.trace.Write(p)
+			} else {
+				k, err = lw.writeOne(p)
+			}
+
+			n += int64(k)
+			if err != nil {
+				return n, err
+			}
+		}
+	}
+
+	if err == nil && urgent {
+		err = lw.flushUrgent()
 	}
 
-	lw.Unlock()
 	return n, err
 }
 
+// writeSegments writes bufs by writeThrough() in segments ending where hot file limits
+// are reached, so hot file is frozen between the same log items as if they were written
+// one by one. Must be called with lw locked.
+func (lw *LogWriter) writeSegments(bufs [][]byte) (n int64, err error) {
+
+	maxSize, maxLines := lw.config.HotMaxSize, lw.config.HotMaxLines
+
+	for len(bufs) > 0 {
+
+		var (
+			size  = lw.hotLen() + int64(lw.bufferLen)
+			lines = lw.fileLines + lw.flushingLines + lw.unflushedLines + lw.bufferLines
+			added int64
+			end   int
+		)
+
+		for end < len(bufs) {
+			p := bufs[end]
+			end++
+
+			size += int64(len(p))
+			if maxLines > 0 {
+				k := int64(bytes.Count(p, newLine))
+				added += k
+				lines += k
+			}
+
+			if maxSize > 0 && size > maxSize || maxLines > 0 && lines >= maxLines {
+				break
+			}
+		}
+
+		k, err := lw.writeThrough(bufs[:end], added)
+		n += int64(k)
+
+		if err == nil {
+			err = lw.checkLimits()
+		}
+		if err != nil {
+			return n, err
+		}
+
+		bufs = bufs[end:]
+	}
+
+	return n, nil
+}
+
+var newLine = []byte{'\n'}
+
+// linesExceeded returns true if hot file and buffer reached config.HotMaxLines
+func (lw *LogWriter) linesExceeded() bool {
+	return lw.config.HotMaxLines > 0 && lw.fileLines+lw.flushingLines+lw.unflushedLines+lw.bufferLines >= lw.config.HotMaxLines
+}
+
+// freezeByLines flushes buffer and freezes hot file, so all counted lines go to cold file
+func (lw *LogWriter) freezeByLines() error {
+	if err := lw.flush(false); err != nil {
+		return err
+	}
+	return lw.freeze(false)
+}
+
+// countHotLines counts newline characters in hot file
+func (lw *LogWriter) countHotLines() (int64, error) {
+
+	if lw.filelen == 0 {
+		return 0, nil
+	}
+
+	f, err := lw.fs.OpenFile(lw.f.Name(), os.O_RDONLY, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var (
+		lines int64
+		buf   = make([]byte, 32*KB)
+
+		// data written so far
+		r = io.LimitReader(f, lw.filelen)
+	)
+
+	for {
+		n, err := r.Read(buf)
+		lines += int64(bytes.Count(buf[:n], newLine))
+
+		if err == io.EOF {
+			return lines, nil
+		}
+
+		if err != nil {
+			return lines, err
+		}
+	}
+}
+
 // openHotFile opens/creates hot log file "%uid%.log"
 func (lw *LogWriter) initHotFile() (err error) {
 
-	lw.f, err = os.OpenFile(
-		filepath.Join(lw.config.HotPath, fmt.Sprintf("%s.%s", lw.uid, lw.hotFileExtension)),
-		os.O_RDWR|os.O_CREATE|os.O_APPEND,
-		0666)
+	prealloc := lw.config.Preallocate && lw.config.HotMaxSize > 0 && lw.fs == OSFS
+
+	lw.hotStarted = lw.clock.Now()
+	lw.f, err = lw.fs.OpenFile(lw.hotName(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 
 	if err != nil {
 		return err
 	}
 
+	if prealloc {
+		// freeze happens after HotMaxSize exceeded by buffer or log item
+		size := lw.config.HotMaxSize + int64(lw.config.BufferSize)
+		if lw.config.BufferMaxSize > lw.config.BufferSize {
+			size = lw.config.HotMaxSize + int64(lw.config.BufferMaxSize)
+		}
+
+		lw.f = preallocate(lw.f.(*os.File), size)
+	}
+
 	fstat, err := lw.f.Stat()
 	if err != nil {
 		return err
@@ -604,18 +1677,36 @@ func (lw *LogWriter) initHotFile() (err error) {
 
 	lw.filelen = fstat.Size()
 
+	if lw.hotFirst.IsZero() && lw.filelen > 0 && lw.trackWrites {
+		// hot file of previous run, write times are bounded by its items and modification
+		lw.hotFirst, lw.hotLast = lw.firstItemTime(lw.f, fstat.ModTime()), fstat.ModTime()
+	}
+
+	lw.fileLines = 0
+	if lw.config.HotMaxLines > 0 {
+		if lw.fileLines, err = lw.countHotLines(); err != nil {
+			return err
+		}
+	}
+
 	// register lw.f in io.MultiWriter()
 	lw.setMode(lw.config.Mode)
 
 	return nil
 }
 
+// timersRequired returns true if any time based action configured
+func (cfg *Config) timersRequired() bool {
+	return (cfg.BufferSize > 0 && cfg.BufferFlushInterval != 0) || cfg.FreezeAtMidnight ||
+		cfg.FreezeInterval != 0 || cfg.FreezeAfterIdle != 0 ||
+		(cfg.Durability == SyncPeriodically && cfg.SyncInterval != 0) || cfg.ReorderWindow != 0
+}
+
 func (lw *LogWriter) startTimers() {
 
-	if (lw.config.BufferSize > 0 && lw.config.BufferFlushInterval != 0) || lw.config.FreezeAtMidnight ||
-		lw.config.FreezeInterval != 0 {
+	if lw.config.timersRequired() {
 		cfg := lw.config
-		go lw.runner(cfg)
+		go lw.runner(cfg, lw.clock, newRunnerTimers(cfg, lw.clock))
 	}
 	return
 }
@@ -624,7 +1715,7 @@ func (lw *LogWriter) startTimers() {
 func (lw *LogWriter) stopTimers() {
 
 	lw.RLock()
-	if (lw.config.BufferSize > 0 && lw.config.BufferFlushInterval != 0) || lw.config.FreezeAtMidnight || lw.config.FreezeInterval != 0 {
+	if lw.config.timersRequired() {
 		lw.RUnlock()
 		lw.stopTimersSignal <- true
 		<-lw.done
@@ -633,15 +1724,3 @@ func (lw *LogWriter) stopTimers() {
 	lw.RUnlock()
 	return
 }
-func defaultColdNameFormatter(uid, ext string, d time.Duration) string {
-
-	tformat := "20060102-150405-.000000"
-
-	// if d (actually config.FreezeInterval) less than 1 second then file name is extended by microseconds
-	// to ensure uniqueness of file names
-	if d < time.Second && d > 0 {
-		tformat += "-.000000"
-	}
-
-	return fmt.Sprintf("%s-%s.%s", uid, time.Now().Format(tformat), ext)
-}
@@ -3,9 +3,20 @@ package logwriter_test
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
 	"github.com/regorov/logwriter"
+	"github.com/regorov/logwriter/logwritertest"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -181,6 +192,2478 @@ func BenchmarkLogWriteBuffered(b *testing.B) {
 	return
 }
 
+func benchmarkLogWriteParallel(b *testing.B, cfg *logwriter.Config) {
+
+	if err := os.Remove("test-par.log"); err != nil {
+		if !os.IsNotExist(err) {
+			b.Fatal(err)
+		}
+	}
+
+	lw, err := logwriter.NewLogWriter("test-par", cfg, true, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n, err := lw.Write(typicalLogItem)
+			if err != nil {
+				b.Fatal(err, n)
+			}
+		}
+	})
+
+	if err := lw.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	return
+}
+
+// Parallel write, buffer written while Write() waits
+func BenchmarkLogWriteParallelBuffered(b *testing.B) {
+	benchmarkLogWriteParallel(b, &logwriter.Config{BufferSize: 256 * logwriter.KB,
+		Mode: logwriter.ProductionMode})
+}
+
+// Parallel write, buffer written in background
+func BenchmarkLogWriteParallelTripleBuffered(b *testing.B) {
+	benchmarkLogWriteParallel(b, &logwriter.Config{BufferSize: 256 * logwriter.KB, BufferCount: 3,
+		Mode: logwriter.ProductionMode})
+}
+
+func benchmarkShardedWriteParallel(b *testing.B, order logwriter.Ordering, perGoroutine bool) {
+
+	if err := os.Remove("test-par.log"); err != nil {
+		if !os.IsNotExist(err) {
+			b.Fatal(err)
+		}
+	}
+
+	lw, err := logwriter.NewLogWriter("test-par",
+		&logwriter.Config{Mode: logwriter.ProductionMode}, true, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	sw := logwriter.NewShardedWriter(lw, &logwriter.ShardedConfig{ShardSize: 256 * logwriter.KB, Order: order})
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		var w io.Writer = sw
+		if perGoroutine {
+			w = sw.Shard()
+		}
+		for pb.Next() {
+			n, err := w.Write(typicalLogItem)
+			if err != nil {
+				b.Fatal(err, n)
+			}
+		}
+	})
+
+	if err := sw.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	return
+}
+
+// Parallel write into per-goroutine shards, shards written one by one
+func BenchmarkShardedWriteParallel(b *testing.B) {
+	benchmarkShardedWriteParallel(b, logwriter.ShardOrder, true)
+}
+
+// Parallel write into per-goroutine shards, shards merged in global order
+func BenchmarkShardedWriteParallelGlobalOrder(b *testing.B) {
+	benchmarkShardedWriteParallel(b, logwriter.GlobalOrder, true)
+}
+
+// Parallel Write() calls, all go into shared shard
+func BenchmarkShardedWriteParallelShared(b *testing.B) {
+	benchmarkShardedWriteParallel(b, logwriter.ShardOrder, false)
+}
+
+// Parallel Write() calls, shards picked round robin and merged in global order
+func BenchmarkShardedWriteParallelSharedGlobalOrder(b *testing.B) {
+	benchmarkShardedWriteParallel(b, logwriter.GlobalOrder, false)
+}
+
+func benchmarkLogWriteOversized(b *testing.B, cfg *logwriter.Config) {
+
+	if err := os.Remove("test-big.log"); err != nil {
+		if !os.IsNotExist(err) {
+			b.Fatal(err)
+		}
+	}
+
+	lw, err := logwriter.NewLogWriter("test-big", cfg, true, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// stack trace like item bigger than buffer
+	big := bytes.Repeat(typicalLogItem, 32)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		item := typicalLogItem
+		if i%8 == 7 {
+			item = big
+		}
+
+		if n, err := lw.Write(item); err != nil {
+			b.Fatal(err, n)
+		}
+	}
+
+	if err := lw.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	return
+}
+
+// Every 8th item bigger than buffer, written together with buffer content by writev
+func BenchmarkLogWriteOversized(b *testing.B) {
+	benchmarkLogWriteOversized(b, &logwriter.Config{BufferSize: 4 * logwriter.KB,
+		Mode: logwriter.ProductionMode})
+}
+
+// AsyncLogWriter writes items queued meanwhile by single writev
+func BenchmarkAsyncLogWriteUnbuffered(b *testing.B) {
+
+	if err := os.Remove("test-async.log"); err != nil {
+		if !os.IsNotExist(err) {
+			b.Fatal(err)
+		}
+	}
+
+	lw, err := logwriter.NewLogWriter("test-async",
+		&logwriter.Config{Mode: logwriter.ProductionMode}, true, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	aw := logwriter.NewAsyncLogWriter(lw, nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if n, err := aw.Write(typicalLogItem); err != nil {
+			b.Fatal(err, n)
+		}
+	}
+
+	if err := aw.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	return
+}
+
+// readDir returns content of files in dir by file name
+func readDir(t *testing.T, dir string) map[string]string {
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := make(map[string]string, len(files))
+	for _, fi := range files {
+		b, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m[fi.Name()] = string(b)
+	}
+
+	return m
+}
+
+// coldContents returns content of all files except hot one sorted by file name
+func coldContents(files map[string]string, hotName string) []string {
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		if name != hotName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	res := make([]string, len(names))
+	for i, name := range names {
+		res[i] = files[name]
+	}
+	return res
+}
+
+func TestFreezeIntervalWithClock(t *testing.T) {
+
+	dir := t.TempDir()
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, time.UTC))
+
+	lw, err := logwriter.NewLogWriter("clock",
+		&logwriter.Config{FreezeInterval: time.Minute,
+			HotPath: dir, ColdPath: dir,
+			Clock: clock, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lw.Write([]byte("first\n"))
+
+	clock.Advance(time.Minute)
+	clock.BlockUntil(1)
+
+	lw.Write([]byte("second\n"))
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readDir(t, dir)
+	if files["clock.log"] != "second\n" {
+		t.Errorf("hot file content %q", files["clock.log"])
+	}
+
+	if cold := coldContents(files, "clock.log"); len(cold) != 1 || cold[0] != "first\n" {
+		t.Errorf("cold files content %q", cold)
+	}
+}
+
+func TestBufferFlushIntervalWithClock(t *testing.T) {
+
+	dir := t.TempDir()
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, time.UTC))
+
+	lw, err := logwriter.NewLogWriter("flush",
+		&logwriter.Config{BufferSize: logwriter.KB, BufferFlushInterval: time.Second,
+			HotPath: dir, Clock: clock, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lw.Close()
+
+	lw.Write([]byte("buffered\n"))
+
+	if files := readDir(t, dir); files["flush.log"] != "" {
+		t.Fatalf("flushed before interval: %q", files["flush.log"])
+	}
+
+	clock.Advance(time.Second)
+	clock.BlockUntil(1)
+
+	if files := readDir(t, dir); files["flush.log"] != "buffered\n" {
+		t.Fatalf("not flushed after interval: %q", files["flush.log"])
+	}
+}
+
+func TestFreezeAtMidnightWithClock(t *testing.T) {
+
+	dir := t.TempDir()
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 23, 59, 0, 0, time.UTC))
+
+	lw, err := logwriter.NewLogWriter("midnight",
+		&logwriter.Config{FreezeAtMidnight: true,
+			HotPath: dir, ColdPath: dir,
+			Clock: clock, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, item := range []string{"day1\n", "day2\n", "day3\n"} {
+		lw.Write([]byte(item))
+		clock.Advance(24 * time.Hour)
+		clock.BlockUntil(1)
+	}
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cold := coldContents(readDir(t, dir), "midnight.log")
+	if len(cold) != 3 || cold[0] != "day1\n" || cold[2] != "day3\n" {
+		t.Errorf("cold files content %q", cold)
+	}
+}
+
+func TestFreezeAfterIdleWithClock(t *testing.T) {
+
+	fs := logwritertest.NewMemFS()
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, time.UTC))
+
+	lw, err := logwriter.NewLogWriter("idle",
+		&logwriter.Config{FreezeAfterIdle: time.Minute,
+			FS: fs, Clock: clock, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lw.Close()
+
+	lw.Write([]byte("burst1\n"))
+	clock.Advance(30 * time.Second)
+	lw.Write([]byte("burst2\n"))
+
+	// timer fires, but hot file was written 30 seconds ago
+	clock.Advance(30 * time.Second)
+	clock.BlockUntil(1)
+
+	if cold := fs.Names("idle-"); len(cold) != 0 {
+		t.Fatalf("frozen before idle period: %q", cold)
+	}
+
+	clock.Advance(30 * time.Second)
+	clock.BlockUntil(1)
+
+	cold := fs.Names("idle-")
+	if len(cold) != 1 {
+		t.Fatalf("cold files %q", cold)
+	}
+
+	if b, _ := fs.ReadFile(cold[0]); string(b) != "burst1\nburst2\n" {
+		t.Errorf("cold file content %q", b)
+	}
+
+	// empty hot file is not frozen
+	clock.Advance(time.Hour)
+	clock.BlockUntil(1)
+
+	if cold := fs.Names("idle-"); len(cold) != 1 {
+		t.Errorf("empty hot file frozen: %q", cold)
+	}
+}
+
+func TestHotMaxLines(t *testing.T) {
+
+	for _, bufferSize := range []int{0, logwriter.KB} {
+
+		fs := logwritertest.NewMemFS()
+
+		// existing lines are counted as well
+		f, _ := fs.OpenFile("lines.log", os.O_CREATE|os.O_WRONLY, 0666)
+		f.Write([]byte("old\n"))
+		f.Close()
+
+		lw, err := logwriter.NewLogWriter("lines",
+			&logwriter.Config{HotMaxLines: 4, BufferSize: bufferSize,
+				FS: fs, Mode: logwriter.ProductionMode}, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, item := range []string{"a\n", "b\n", "c\nd\n", "e\n"} {
+			if _, err := lw.Write([]byte(item)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := lw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		names := fs.Names("lines-")
+		if len(names) != 1 {
+			t.Fatalf("buffer %d: cold files %q", bufferSize, names)
+		}
+
+		if b, _ := fs.ReadFile(names[0]); string(b) != "old\na\nb\nc\nd\n" {
+			t.Errorf("buffer %d: cold file content %q", bufferSize, b)
+		}
+
+		if b, _ := fs.ReadFile("lines.log"); string(b) != "e\n" {
+			t.Errorf("buffer %d: hot file content %q", bufferSize, b)
+		}
+	}
+}
+
+func TestLineRecords(t *testing.T) {
+
+	fs := logwritertest.NewMemFS()
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, time.UTC))
+
+	lw, err := logwriter.NewLogWriter("lines",
+		&logwriter.Config{Records: logwriter.LineRecords, BufferSize: 16, HotMaxSize: 8,
+			FS: fs, Clock: clock, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// line written in pieces
+	for _, item := range []string{"first ", "line\nsec", "ond line\nthi", "rd"} {
+		if n, err := lw.Write([]byte(item)); n != len(item) || err != nil {
+			t.Fatalf("Write(%q) = %d, %v", item, n, err)
+		}
+
+		// incomplete line never reaches a file
+		if err := lw.FlushBuffer(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := lw.FreezeHotFile(); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Second)
+	}
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var contents []string
+	for _, name := range fs.Names("lines") {
+		b, _ := fs.ReadFile(name)
+		contents = append(contents, string(b))
+	}
+
+	// cold files sorted by name, hot file "lines.log" is the last one
+	expected := []string{"first line\n", "second line\n", "third"}
+	if len(contents) != len(expected) {
+		t.Fatalf("files content %q", contents)
+	}
+
+	for i := range expected {
+		if contents[i] != expected[i] {
+			t.Errorf("files content %q, expected %q", contents, expected)
+			break
+		}
+	}
+}
+
+func TestLineRecordsOversized(t *testing.T) {
+
+	fs := logwritertest.NewMemFS()
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, time.UTC))
+
+	lw, err := logwriter.NewLogWriter("long",
+		&logwriter.Config{Records: logwriter.LineRecords, HotMaxSize: 8,
+			FS: fs, Clock: clock, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// line over 64 KB is not kept in memory, its head is written and frozen
+	head := bytes.Repeat([]byte("x"), 64*logwriter.KB)
+	for _, item := range [][]byte{head, []byte("tail\n")} {
+		if n, err := lw.Write(item); n != len(item) || err != nil {
+			t.Fatalf("Write() = %d, %v", n, err)
+		}
+		clock.Advance(time.Second)
+	}
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names := fs.Names("long")
+	if len(names) != 2 {
+		t.Fatalf("files %q", names)
+	}
+
+	if b, _ := fs.ReadFile(names[0]); !bytes.Equal(b, head) {
+		t.Errorf("cold file of %d bytes", len(b))
+	}
+
+	if b, _ := fs.ReadFile("long.log"); string(b) != "tail\n" {
+		t.Errorf("hot file content %q", b)
+	}
+}
+
+func TestLineRecordsShortWrite(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+	fs := logwritertest.NewFaultyFS(mem)
+
+	lw, err := logwriter.NewLogWriter("short",
+		&logwriter.Config{Records: logwriter.LineRecords, FS: fs, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lw.Close()
+
+	if n, err := lw.Write([]byte("12")); n != 2 || err != nil {
+		t.Fatalf("Write() = %d, %v", n, err)
+	}
+
+	// 4 bytes of "12" + "345678\n" are written, 2 of them are the new ones
+	fs.Inject(logwritertest.Fault{Op: logwritertest.OpWrite, Short: true, Times: 1})
+
+	if n, err := lw.Write([]byte("345678\n")); n != 2 || err != io.ErrShortWrite {
+		t.Errorf("Write() = %d, %v", n, err)
+	}
+
+	// caller retries the rest
+	if n, err := lw.Write([]byte("5678\n")); n != 5 || err != nil {
+		t.Errorf("Write() = %d, %v", n, err)
+	}
+
+	if b, _ := mem.ReadFile("short.log"); string(b) != "12345678\n" {
+		t.Errorf("hot file content %q", b)
+	}
+}
+
+func TestGroupRecords(t *testing.T) {
+
+	fs := logwritertest.NewMemFS()
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, time.UTC))
+
+	lw, err := logwriter.NewLogWriter("group",
+		&logwriter.Config{Records: logwriter.GroupRecords,
+			Continuation: regexp.MustCompile(`^(\s|goroutine |panic:|[\w./*()]+\(.*\)$|$)`),
+			BufferSize:   logwriter.KB, BufferFlushInterval: time.Second, HotMaxLines: 2,
+			FS: fs, Clock: clock, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trace := "panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n\t/main.go:5 +0x1d\n"
+	for _, item := range []string{"start\n", "failure\n", trace} {
+		lw.Write([]byte(item))
+	}
+
+	// the first tick finds fresh group, the next one writes it
+	clock.Advance(time.Second)
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	clock.BlockUntil(1)
+
+	lw.Write([]byte("restart\n"))
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names := fs.Names("group")
+	if len(names) != 2 {
+		t.Fatalf("files %q", names)
+	}
+
+	// HotMaxLines reached by group, so whole group is in cold file
+	if b, _ := fs.ReadFile(names[0]); string(b) != "start\nfailure\n"+trace {
+		t.Errorf("cold file content %q", b)
+	}
+
+	if b, _ := fs.ReadFile("group.log"); string(b) != "restart\n" {
+		t.Errorf("hot file content %q", b)
+	}
+}
+
+func TestBufferCount(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+	fs := logwritertest.NewFaultyFS(mem)
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, time.UTC))
+
+	lw, err := logwriter.NewLogWriter("double",
+		&logwriter.Config{BufferSize: 16, BufferCount: 3,
+			FS: fs, Clock: clock, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// slow disk, writes queue up behind
+	fs.Inject(logwritertest.Fault{Op: logwritertest.OpWrite, Delay: time.Millisecond})
+
+	var expected bytes.Buffer
+	for i := 0; i < 100; i++ {
+		item := []byte(strconv.Itoa(i) + "\n")
+		if i%10 == 0 {
+			// oversized item bypasses buffers
+			item = append(bytes.Repeat([]byte("X"), 20), item...)
+		}
+
+		if n, err := lw.Write(item); n != len(item) || err != nil {
+			t.Fatalf("Write() = %d, %v", n, err)
+		}
+		expected.Write(item)
+
+		if i == 50 {
+			if err := lw.FreezeHotFile(); err != nil {
+				t.Fatal(err)
+			}
+			clock.Advance(time.Second)
+		}
+	}
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var content []byte
+	for _, name := range mem.Names("double") {
+		b, _ := mem.ReadFile(name)
+		content = append(content, b...)
+	}
+
+	if string(content) != expected.String() {
+		t.Errorf("content %q, expected %q", content, expected.String())
+	}
+}
+
+func TestBufferCountWriteFailure(t *testing.T) {
+
+	fs := logwritertest.NewFaultyFS(nil)
+	errs := &logwritertest.ErrorRecorder{}
+
+	lw, err := logwriter.NewLogWriter("double",
+		&logwriter.Config{BufferSize: 16, BufferCount: 2,
+			FS: fs, Mode: logwriter.ProductionMode}, false, errs.Handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs.Inject(logwritertest.Fault{Op: logwritertest.OpWrite, Err: logwritertest.ErrNoSpace, Times: 1})
+
+	// buffer swap is not affected by background failure
+	for _, item := range []string{"first line\n", "second line\n"} {
+		if _, err := lw.Write([]byte(item)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := lw.FlushBuffer(); !errors.Is(err, logwritertest.ErrNoSpace) {
+		t.Errorf("FlushBuffer() = %v", err)
+	}
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs.Count() != 1 {
+		t.Errorf("error handler called %d times, expected 1", errs.Count())
+	}
+}
+
+func TestBufferCountWriteRetry(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+	fs := logwritertest.NewFaultyFS(mem)
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, time.UTC))
+
+	lw, err := logwriter.NewLogWriter("double",
+		&logwriter.Config{BufferSize: 16, BufferCount: 2, HotMaxLines: 3,
+			FS: fs, Clock: clock, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs.Inject(logwritertest.Fault{Op: logwritertest.OpWrite, Err: logwritertest.ErrNoSpace, Times: 1})
+
+	// the first line fails in background
+	for _, item := range []string{"first line\n", "second line\n"} {
+		if _, err := lw.Write([]byte(item)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// failed data is written again before the buffer
+	if err := lw.FlushBuffer(); !errors.Is(err, logwritertest.ErrNoSpace) {
+		t.Errorf("FlushBuffer() = %v", err)
+	}
+
+	// failed line is counted once, the third one reaches HotMaxLines
+	if _, err := lw.Write([]byte("third line\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := mem.ReadFile("double-20171127-100000.000000.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "first line\nsecond line\nthird line\n" {
+		t.Errorf("cold file content %q", b)
+	}
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// gateWriter blocks the first Write() until released
+type gateWriter struct {
+	entered chan struct{}
+	release chan struct{}
+	once    sync.Once
+	buf     bytes.Buffer
+}
+
+func newGateWriter() *gateWriter {
+	return &gateWriter{entered: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (g *gateWriter) Write(p []byte) (int, error) {
+	g.once.Do(func() {
+		close(g.entered)
+		<-g.release
+	})
+	return g.buf.Write(p)
+}
+
+func TestDurability(t *testing.T) {
+
+	cases := []struct {
+		cfg   logwriter.Config
+		syncs int
+	}{
+		{logwriter.Config{Durability: logwriter.DurabilityNone}, 0},
+		// freeze and close
+		{logwriter.Config{Durability: logwriter.SyncOnFreeze}, 2},
+		// every 20 bytes, freeze and close
+		{logwriter.Config{Durability: logwriter.SyncPeriodically, SyncEveryBytes: 20}, 3},
+		// every write, freeze and close
+		{logwriter.Config{Durability: logwriter.SyncOnFlush}, 6},
+	}
+
+	for _, c := range cases {
+		fs := logwritertest.NewFaultyFS(nil)
+
+		cfg := c.cfg
+		cfg.FS = fs
+		cfg.Mode = logwriter.ProductionMode
+
+		lw, err := logwriter.NewLogWriter("durable", &cfg, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 4; i++ {
+			if _, err := lw.Write([]byte("123456789\n")); err != nil {
+				t.Fatal(err)
+			}
+
+			if i == 2 {
+				if err := lw.FreezeHotFile(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		if err := lw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if n := fs.Calls(logwritertest.OpSync); n != c.syncs {
+			t.Errorf("durability %d: %d syncs, expected %d", c.cfg.Durability, n, c.syncs)
+		}
+
+		dirSynced := fs.Calls(logwritertest.OpSyncDir) > 0
+		if dirSynced != (c.cfg.Durability != logwriter.DurabilityNone) {
+			t.Errorf("durability %d: folder synced %v", c.cfg.Durability, dirSynced)
+		}
+	}
+}
+
+func TestSyncIntervalWithClock(t *testing.T) {
+
+	fs := logwritertest.NewFaultyFS(nil)
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, time.UTC))
+
+	lw, err := logwriter.NewLogWriter("durable",
+		&logwriter.Config{Durability: logwriter.SyncPeriodically, SyncInterval: time.Second,
+			FS: fs, Clock: clock, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lw.Close()
+
+	lw.Write([]byte("unsynced\n"))
+
+	clock.Advance(time.Second)
+	clock.BlockUntil(1)
+
+	if n := fs.Calls(logwritertest.OpSync); n != 1 {
+		t.Fatalf("%d syncs after interval, expected 1", n)
+	}
+
+	// nothing written since previous sync
+	clock.Advance(time.Second)
+	clock.BlockUntil(1)
+
+	if n := fs.Calls(logwritertest.OpSync); n != 1 {
+		t.Fatalf("%d syncs after idle interval, expected 1", n)
+	}
+}
+
+func TestSyncError(t *testing.T) {
+
+	for _, bufferSize := range []int{0, logwriter.KB} {
+
+		mem := logwritertest.NewMemFS()
+		fs := logwritertest.NewFaultyFS(mem)
+
+		lw, err := logwriter.NewLogWriter("durable",
+			&logwriter.Config{Durability: logwriter.SyncOnFlush, BufferSize: bufferSize,
+				FS: fs, Mode: logwriter.ProductionMode}, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fs.Inject(logwritertest.Fault{Op: logwritertest.OpSync, Err: logwritertest.ErrIO, Times: 1})
+
+		// data is written, sync failure must not make caller or buffer write it again
+		item := []byte("written\n")
+		n, err := lw.Write(item)
+		if bufferSize > 0 && err == nil {
+			err = lw.FlushBuffer()
+		}
+
+		var syncErr *logwriter.SyncError
+		if n != len(item) || !errors.As(err, &syncErr) || !errors.Is(err, logwritertest.ErrIO) {
+			t.Errorf("buffer %d: Write() = %d, %v", bufferSize, n, err)
+		}
+
+		if err := lw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if b, _ := mem.ReadFile("durable.log"); string(b) != "written\n" {
+			t.Errorf("buffer %d: hot file content %q", bufferSize, b)
+		}
+	}
+}
+
+func TestPreallocate(t *testing.T) {
+
+	dir := t.TempDir()
+	hotName := filepath.Join(dir, "prealloc.log")
+
+	// hot file of previous run, trailing zeros are data
+	before := "before restart\n\x00\x00"
+	if err := ioutil.WriteFile(hotName, []byte(before), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	lw, err := logwriter.NewLogWriter("prealloc",
+		&logwriter.Config{HotMaxSize: logwriter.MB, Preallocate: true,
+			HotPath: dir, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := lw.Write([]byte("after restart\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// crash leaves written data only, disk space is allocated beyond it
+	if fi, err := os.Stat(hotName); err != nil {
+		t.Fatal(err)
+	} else if fi.Size() != int64(len(before+"after restart\n")) {
+		t.Errorf("hot file size %d", fi.Size())
+	} else if size, ok := allocatedSize(fi); ok && size < logwriter.MB {
+		t.Errorf("hot file allocated size %d", size)
+	}
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if files := readDir(t, dir); files["prealloc.log"] != before+"after restart\n" {
+		t.Errorf("hot file content %q", files["prealloc.log"])
+	}
+}
+
+func TestAdaptiveBuffer(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, time.UTC))
+
+	lw, err := logwriter.NewLogWriter("adaptive",
+		&logwriter.Config{BufferSize: logwriter.KB, BufferMaxSize: 64 * logwriter.KB,
+			BufferFlushTarget: time.Second, FS: mem, Clock: clock, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lw.Close()
+
+	hotSize := func() int {
+		b, _ := mem.ReadFile("adaptive.log")
+		return len(b)
+	}
+
+	item := append(bytes.Repeat([]byte("A"), 99), '\n')
+
+	// 10KB per second
+	for i := 0; i < 11; i++ {
+		clock.Advance(10 * time.Millisecond)
+		lw.Write(item)
+	}
+
+	if n := hotSize(); n != 1000 {
+		t.Fatalf("hot file size %d, expected full minimal buffer", n)
+	}
+
+	// buffer grown to keep data for about a second
+	for i := 0; i < 50; i++ {
+		clock.Advance(10 * time.Millisecond)
+		lw.Write(item)
+	}
+
+	if n := hotSize(); n != 1000 {
+		t.Fatalf("hot file size %d, buffer did not grow", n)
+	}
+
+	// idle, buffer shrinks after flush
+	clock.Advance(time.Minute)
+	if err := lw.FlushBuffer(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 11; i++ {
+		lw.Write(item)
+	}
+
+	if n := hotSize(); n != 7100 {
+		t.Fatalf("hot file size %d, buffer did not shrink", n)
+	}
+}
+
+func TestAdaptiveBufferHotMaxSize(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, time.UTC))
+
+	lw, err := logwriter.NewLogWriter("adaptive",
+		&logwriter.Config{BufferSize: logwriter.KB, BufferMaxSize: 64 * logwriter.KB, BufferCount: 2,
+			HotMaxSize: 128 * logwriter.KB, FS: mem, Clock: clock, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	item := append(bytes.Repeat([]byte("A"), 999), '\n')
+
+	// 100KB per second, buffer grows to BufferMaxSize
+	for i := 0; i < 1000; i++ {
+		clock.Advance(10 * time.Millisecond)
+		if _, err := lw.Write(item); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	total := 0
+	for _, name := range mem.Names("adaptive") {
+		b, _ := mem.ReadFile(name)
+		if len(b) > 128*logwriter.KB {
+			t.Errorf("file %s size %d exceeds HotMaxSize", name, len(b))
+		}
+		total += len(b)
+	}
+
+	if total != 1000*len(item) {
+		t.Errorf("%d bytes written, expected %d", total, 1000*len(item))
+	}
+}
+
+func TestWriteUrgent(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+
+	lw, err := logwriter.NewLogWriter("urgent",
+		&logwriter.Config{BufferSize: logwriter.KB, FS: mem, Mode: logwriter.ProductionMode,
+			Urgent: func(p []byte) bool { return bytes.HasPrefix(p, []byte("ERROR")) }}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lw.Close()
+
+	hot := func() string {
+		b, _ := mem.ReadFile("urgent.log")
+		return string(b)
+	}
+
+	lw.Write([]byte("INFO started\n"))
+	if s := hot(); s != "" {
+		t.Fatalf("flushed before urgent item: %q", s)
+	}
+
+	lw.Write([]byte("ERROR failed\n"))
+	if s := hot(); s != "INFO started\nERROR failed\n" {
+		t.Fatalf("detected urgent item not flushed: %q", s)
+	}
+
+	lw.Write([]byte("INFO retry\n"))
+	lw.WriteUrgent([]byte("WARN slow\n"))
+	if s := hot(); s != "INFO started\nERROR failed\nINFO retry\nWARN slow\n" {
+		t.Fatalf("WriteUrgent() item not flushed: %q", s)
+	}
+}
+
+func TestWriteOversized(t *testing.T) {
+
+	dir := t.TempDir()
+
+	lw, err := logwriter.NewLogWriter("big",
+		&logwriter.Config{BufferSize: 16, HotPath: dir, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aw := logwriter.NewAsyncLogWriter(lw, nil)
+
+	var expected bytes.Buffer
+	for i := 0; i < 100; i++ {
+		item := []byte(strconv.Itoa(i) + "\n")
+		if i%7 == 0 {
+			// bigger than buffer, written together with buffer content
+			item = append(bytes.Repeat([]byte("X"), 20), item...)
+		}
+
+		if _, err := aw.Write(item); err != nil {
+			t.Fatal(err)
+		}
+		expected.Write(item)
+	}
+
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if files := readDir(t, dir); files["big.log"] != expected.String() {
+		t.Errorf("content %q, expected %q", files["big.log"], expected.String())
+	}
+}
+
+func TestAsyncLogWriterHotMaxSize(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+	fs := logwritertest.NewFaultyFS(mem)
+
+	lw, err := logwriter.NewLogWriter("batch",
+		&logwriter.Config{HotMaxSize: 100, FS: fs, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// slow writes, items queued meanwhile are written as a batch
+	fs.Inject(logwritertest.Fault{Op: logwritertest.OpWrite, Delay: time.Millisecond})
+
+	aw := logwriter.NewAsyncLogWriter(lw, &logwriter.AsyncConfig{QueueLen: 100})
+
+	for i := 0; i < 100; i++ {
+		if _, err := fmt.Fprintf(aw, "item %04d\n", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// hot file is frozen after the item exceeding HotMaxSize, as if items written one by one
+	var content string
+	for _, name := range mem.Names("batch") {
+		b, _ := mem.ReadFile(name)
+		if name != "batch.log" && len(b) != 110 {
+			t.Errorf("cold file %s size %d, expected 110", name, len(b))
+		}
+		content += string(b)
+	}
+
+	if len(content) != 1000 {
+		t.Errorf("%d bytes written, expected 1000", len(content))
+	}
+}
+
+func TestRouter(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+
+	newWriter := func(uid string) *logwriter.LogWriter {
+		lw, err := logwriter.NewLogWriter(uid,
+			&logwriter.Config{BufferSize: logwriter.KB, FS: mem, Mode: logwriter.ProductionMode}, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lw
+	}
+
+	all, errs, debug := newWriter("app"), newWriter("app.error"), newWriter("app.debug")
+
+	r := logwriter.NewRouter(&logwriter.RouterConfig{Routes: []logwriter.Route{
+		{Writer: all, Match: logwriter.MinLevel(logwriter.LevelInfo)},
+		{Writer: errs, Match: logwriter.MinLevel(logwriter.LevelError)},
+		{Writer: debug, Match: logwriter.LevelRange(logwriter.LevelDebug, logwriter.LevelDebug)},
+	}})
+
+	for _, item := range []string{
+		"time=10:00 level=INFO msg=started\n",
+		"time=10:01 level=DEBUG msg=connected\n",
+		"[ERROR] failed, INFO follows\n",
+	} {
+		if _, err := r.Write([]byte(item)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// error flushed immediately
+	if b, _ := mem.ReadFile("app.error.log"); string(b) != "[ERROR] failed, INFO follows\n" {
+		t.Errorf("error route %q", b)
+	}
+
+	if _, err := r.WriteLevel(logwriter.LevelWarn, []byte("slow\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"app.log":       "time=10:00 level=INFO msg=started\n[ERROR] failed, INFO follows\nslow\n",
+		"app.error.log": "[ERROR] failed, INFO follows\n",
+		"app.debug.log": "time=10:01 level=DEBUG msg=connected\n",
+	}
+
+	for name, content := range expected {
+		if b, _ := mem.ReadFile(name); string(b) != content {
+			t.Errorf("%s content %q, expected %q", name, b, content)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+
+	cases := []struct {
+		record string
+		level  logwriter.Level
+	}{
+		{"time=10:00 level=ERROR msg=failed\n", logwriter.LevelError},
+		{`{"time":"10:00","level":"WARN","msg":"slow"}` + "\n", logwriter.LevelWarn},
+		{"[DEBUG] connected\n", logwriter.LevelDebug},
+		{"2017-11-27 10:00:00 PANIC: out of memory\n", logwriter.LevelError + 4},
+		{"WARNING disk is almost full\n", logwriter.LevelWarn},
+		{"[ERROR] failed, INFO follows\n", logwriter.LevelError},
+		// level names within message
+		{"time=10:00 level=INFO msg=\"no ERROR occurred\"\n", logwriter.LevelInfo},
+		{"request failed with ERROR 500\n", logwriter.LevelInfo},
+		{"ERRORS counted: 0\n", logwriter.LevelInfo},
+	}
+
+	for _, c := range cases {
+		if level := logwriter.ParseLevel([]byte(c.record)); level != c.level {
+			t.Errorf("ParseLevel(%q) = %v, expected %v", c.record, level, c.level)
+		}
+	}
+}
+
+func TestTrace(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, time.UTC))
+
+	lw, err := logwriter.NewLogWriter("app",
+		&logwriter.Config{HotPath: "log", ColdPath: "arch", FS: mem, Clock: clock, Mode: logwriter.ProductionMode,
+			Trace:      &logwriter.Config{HotMaxSize: 20, CompressColdFile: true},
+			TraceMatch: func(p []byte) bool { return bytes.HasPrefix(p, []byte("TRACE")) }}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lw.Write([]byte("started\n"))
+	lw.Write([]byte("TRACE request body\n"))
+	if _, err := lw.WriteTrace([]byte("response body\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := mem.ReadFile("log/app.log"); string(b) != "started\n" {
+		t.Errorf("hot file %q", b)
+	}
+
+	// trace file frozen when size exceeded
+	if b, _ := mem.ReadFile("log/app.trc"); len(b) != 0 {
+		t.Errorf("hot trace file %q", b)
+	}
+
+	names := mem.Names("arch/app-")
+	if len(names) != 1 || !strings.HasSuffix(names[0], ".trc."+logwriter.CompressedColdFileExtension) {
+		t.Fatalf("cold trace files %v", names)
+	}
+
+	b, _ := mem.ReadFile(names[0])
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if content, _ := ioutil.ReadAll(zr); string(content) != "TRACE request body\nresponse body\n" {
+		t.Errorf("cold trace file %q", content)
+	}
+
+	if _, err := lw.WriteTrace([]byte("closed\n")); err == nil {
+		t.Error("WriteTrace() succeeded after Close()")
+	}
+}
+
+func TestRecent(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, time.UTC))
+
+	lw, err := logwriter.NewLogWriter("app",
+		&logwriter.Config{BufferSize: logwriter.KB, RecentItems: 3, FS: mem, Clock: clock,
+			Mode:       logwriter.ProductionMode,
+			RecentOnly: func(p []byte) bool { return bytes.HasPrefix(p, []byte("DEBUG")) }}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, item := range []string{"INFO 1\n", "DEBUG 2\n", "INFO 3\n", "DEBUG 4\n"} {
+		lw.Write([]byte(item))
+	}
+
+	var recent []string
+	for _, p := range lw.Recent() {
+		recent = append(recent, string(p))
+	}
+
+	if strings.Join(recent, "") != "DEBUG 2\nINFO 3\nDEBUG 4\n" {
+		t.Errorf("Recent() = %q", recent)
+	}
+
+	name, err := lw.DumpRecentFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := mem.ReadFile(name); string(b) != "DEBUG 2\nINFO 3\nDEBUG 4\n" {
+		t.Errorf("dump %s content %q", name, b)
+	}
+
+	// the same time, previous dump is kept
+	if again, err := lw.DumpRecentFile(); err != nil {
+		t.Fatal(err)
+	} else if again != "app-20171127-100000.000000-1.dump" {
+		t.Errorf("dump file %s, expected sequence suffix", again)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != "crash" {
+				t.Errorf("recovered %v", r)
+			}
+		}()
+		defer lw.DumpRecentOnPanic()
+
+		clock.Advance(time.Second)
+		lw.Write([]byte("ERROR 5\n"))
+		panic("crash")
+	}()
+
+	if dumps := mem.Names("app-"); len(dumps) != 3 {
+		t.Errorf("dump files %v", dumps)
+	}
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// debug items are kept in memory only
+	if b, _ := mem.ReadFile("app.log"); string(b) != "INFO 1\nINFO 3\nERROR 5\n" {
+		t.Errorf("hot file %q", b)
+	}
+}
+
+func TestFlightRecorder(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+
+	lw, err := logwriter.NewLogWriter("app",
+		&logwriter.Config{RecentItems: 2, CaptureAfter: 1, FS: mem, Mode: logwriter.ProductionMode,
+			Trace:          &logwriter.Config{},
+			RecentOnly:     func(p []byte) bool { return bytes.HasPrefix(p, []byte("DEBUG")) },
+			CaptureTrigger: func(p []byte) bool { return bytes.HasPrefix(p, []byte("ERROR")) }}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, item := range []string{
+		"DEBUG 1\n", "DEBUG 2\n", "ERROR 3\n", "DEBUG 4\n", "DEBUG 5\n",
+		"ERROR 6\n", "INFO 7\n", "DEBUG 8\n",
+	} {
+		lw.Write([]byte(item))
+	}
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := mem.ReadFile("app.log"); string(b) != "ERROR 3\nERROR 6\nINFO 7\n" {
+		t.Errorf("hot file %q", b)
+	}
+
+	// items around errors, every item captured once
+	if b, _ := mem.ReadFile("app.trc"); string(b) != "DEBUG 2\nERROR 3\nDEBUG 4\nDEBUG 5\nERROR 6\nINFO 7\n" {
+		t.Errorf("trace file %q", b)
+	}
+}
+
+func TestReorderWindowWriteFailure(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+	fs := logwritertest.NewFaultyFS(mem)
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, time.UTC))
+
+	lw, err := logwriter.NewLogWriter("app",
+		&logwriter.Config{ReorderWindow: time.Second, FS: fs, Clock: clock,
+			Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lw.Write([]byte("first\n"))
+
+	fs.Inject(logwritertest.Fault{Op: logwritertest.OpWrite, Err: logwritertest.ErrNoSpace, Times: 1})
+
+	if _, err := lw.WriteUrgent([]byte("second\n")); !errors.Is(err, logwritertest.ErrNoSpace) {
+		t.Errorf("WriteUrgent() = %v", err)
+	}
+
+	// held items are kept on failure
+	if _, err := lw.WriteUrgent([]byte("third\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := mem.ReadFile("app.log"); string(b) != "first\nsecond\nthird\n" {
+		t.Errorf("hot file %q", b)
+	}
+}
+
+func TestFlightRecorderTraced(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+
+	cfg := logwriter.Config{RecentItems: 3, CaptureAfter: 1, FS: mem, Mode: logwriter.ProductionMode,
+		Trace:          &logwriter.Config{},
+		TraceMatch:     func(p []byte) bool { return bytes.HasPrefix(p, []byte("TRACE")) },
+		CaptureTrigger: func(p []byte) bool { return bytes.Contains(p, []byte("ERROR")) }}
+
+	lw, err := logwriter.NewLogWriter("app", &cfg, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, item := range []string{"TRACE 1\n", "INFO 2\n", "TRACE ERROR 3\n", "INFO 4\n"} {
+		lw.Write([]byte(item))
+	}
+
+	// captured items are not captured again after ring resized
+	cfg.RecentItems = 4
+	if err := lw.SetConfig(&cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	lw.Write([]byte("ERROR 5\n"))
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// traced items are written once
+	if b, _ := mem.ReadFile("app.trc"); string(b) != "TRACE 1\nINFO 2\nTRACE ERROR 3\nINFO 4\nERROR 5\n" {
+		t.Errorf("trace file %q", b)
+	}
+}
+
+func TestReorderWindow(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 500000000, time.UTC))
+
+	lw, err := logwriter.NewLogWriter("app",
+		&logwriter.Config{ReorderWindow: time.Second, FS: mem, Clock: clock,
+			Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lw.Close()
+
+	hot := func() string {
+		b, _ := mem.ReadFile("app.log")
+		return string(b)
+	}
+
+	for _, item := range []string{
+		"2017-11-27T10:00:00.3Z c\n",
+		"  continuation of c\n",
+		"time=2017-11-27T10:00:00.1Z msg=a\n",
+		`{"time":"2017-11-27T10:00:00.2Z","msg":"b"}` + "\n",
+	} {
+		lw.Write([]byte(item))
+	}
+
+	if s := hot(); s != "" {
+		t.Fatalf("written before window passed: %q", s)
+	}
+
+	clock.Advance(time.Second)
+	clock.BlockUntil(1)
+
+	expected := "time=2017-11-27T10:00:00.1Z msg=a\n" +
+		`{"time":"2017-11-27T10:00:00.2Z","msg":"b"}` + "\n" +
+		"2017-11-27T10:00:00.3Z c\n  continuation of c\n"
+
+	if s := hot(); s != expected {
+		t.Fatalf("hot file %q, expected %q", s, expected)
+	}
+
+	// window is written into cold file on freeze
+	lw.Write([]byte("2017-11-27T10:00:01.5Z e\n"))
+	lw.Write([]byte("2017-11-27T10:00:01.4Z d\n"))
+
+	if err := lw.FreezeHotFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	names := mem.Names("app-")
+	if len(names) != 1 {
+		t.Fatalf("cold files %v", names)
+	}
+
+	expected += "2017-11-27T10:00:01.4Z d\n2017-11-27T10:00:01.5Z e\n"
+	if b, _ := mem.ReadFile(names[0]); string(b) != expected {
+		t.Errorf("cold file %q, expected %q", b, expected)
+	}
+}
+
+func TestAsyncLogWriterOverflow(t *testing.T) {
+
+	tests := []struct {
+		overflow logwriter.OverflowPolicy
+		expected string
+	}{
+		{logwriter.OverflowDropNewest, "1\n2\n3\nlogwriter: 3 log items dropped\n"},
+		{logwriter.OverflowDropOldest, "1\n5\n6\nlogwriter: 3 log items dropped\n"},
+		{logwriter.OverflowDropLow, "1\n2\n3\nlogwriter: 3 log items dropped\n"},
+	}
+
+	for _, test := range tests {
+
+		gate := newGateWriter()
+		aw := logwriter.NewAsyncLogWriter(gate, &logwriter.AsyncConfig{QueueLen: 2,
+			Overflow:   test.overflow,
+			Priority:   func([]byte) logwriter.Priority { return logwriter.LowPriority },
+			DropNotice: logwriter.DefaultDropNotice})
+
+		aw.Write([]byte("1\n"))
+
+		// routine holds the first item, the queue is empty
+		<-gate.entered
+
+		for _, item := range []string{"2\n", "3\n", "4\n", "5\n", "6\n"} {
+			if n, err := aw.Write([]byte(item)); n != len(item) || err != nil {
+				t.Fatalf("Write() = %d, %v", n, err)
+			}
+		}
+
+		if aw.Dropped() != 3 {
+			t.Errorf("policy %d: dropped %d, expected 3", test.overflow, aw.Dropped())
+		}
+
+		close(gate.release)
+
+		if err := aw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if gate.buf.String() != test.expected {
+			t.Errorf("policy %d: written %q, expected %q", test.overflow, gate.buf.String(), test.expected)
+		}
+
+		if _, err := aw.Write([]byte("late\n")); err != logwriter.ErrWriterClosed {
+			t.Errorf("Write() after Close() = %v", err)
+		}
+	}
+}
+
+func TestShardedWriterGlobalOrder(t *testing.T) {
+
+	fs := logwritertest.NewMemFS()
+
+	lw, err := logwriter.NewLogWriter("sharded",
+		&logwriter.Config{FS: fs, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sw := logwriter.NewShardedWriter(lw, &logwriter.ShardedConfig{Shards: 4, ShardSize: 64,
+		Order: logwriter.GlobalOrder})
+
+	var expected bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		item := strconv.Itoa(i) + "\n"
+		if i%100 == 0 {
+			item = strings.Repeat("X", 100) + item
+		}
+		sw.Write([]byte(item))
+		expected.WriteString(item)
+	}
+
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := fs.ReadFile("sharded.log"); string(b) != expected.String() {
+		t.Errorf("hot file content %q", b)
+	}
+}
+
+func TestShardedWriterPerGoroutineOrder(t *testing.T) {
+
+	for _, order := range []logwriter.Ordering{logwriter.ShardOrder, logwriter.GlobalOrder} {
+
+		fs := logwritertest.NewMemFS()
+
+		lw, err := logwriter.NewLogWriter("sharded",
+			&logwriter.Config{FS: fs, Mode: logwriter.ProductionMode}, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sw := logwriter.NewShardedWriter(lw, &logwriter.ShardedConfig{Shards: 3, ShardSize: 128,
+			Order: order})
+
+		wg := sync.WaitGroup{}
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+
+				// Write() keeps order of calls as well
+				var w io.Writer = sw
+				if g%2 == 0 {
+					w = sw.Shard()
+				}
+
+				for i := 0; i < 500; i++ {
+					fmt.Fprintf(w, "%d %d\n", g, i)
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		if err := sw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		b, _ := fs.ReadFile("sharded.log")
+		next := make(map[int]int)
+		for _, line := range strings.Split(strings.TrimSuffix(string(b), "\n"), "\n") {
+			var g, i int
+			if _, err := fmt.Sscanf(line, "%d %d", &g, &i); err != nil || next[g] != i {
+				t.Fatalf("order %d: unexpected line %q", order, line)
+			}
+			next[g]++
+		}
+
+		if len(next) != 8 {
+			t.Errorf("order %d: lines of %d goroutines found", order, len(next))
+		}
+	}
+}
+
+func TestShardedWriterFlushInterval(t *testing.T) {
+
+	fs := logwritertest.NewMemFS()
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, time.UTC))
+
+	lw, err := logwriter.NewLogWriter("sharded",
+		&logwriter.Config{FS: fs, Clock: clock, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// idle shard is flushed by default interval
+	sw := logwriter.NewShardedWriter(lw, &logwriter.ShardedConfig{Shards: 2, ShardSize: 128})
+
+	sw.Write([]byte("first\n"))
+	sw.Shard().Write([]byte("second\n"))
+
+	if b, _ := fs.ReadFile("sharded.log"); len(b) != 0 {
+		t.Errorf("hot file content %q before flush", b)
+	}
+
+	clock.Advance(logwriter.DefaultShardFlushInterval)
+	clock.BlockUntil(1)
+
+	if b, _ := fs.ReadFile("sharded.log"); string(b) != "first\nsecond\n" {
+		t.Errorf("hot file content %q", b)
+	}
+
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemFSFreezeCompressed(t *testing.T) {
+
+	fs := logwritertest.NewMemFS()
+
+	lw, err := logwriter.NewLogWriter("mem",
+		&logwriter.Config{HotPath: "hot", ColdPath: "cold", CompressColdFile: true,
+			FS: fs, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lw.Write([]byte("frozen\n"))
+
+	if err := lw.FreezeHotFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	lw.Write([]byte("hot\n"))
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := fs.ReadFile("hot/mem.log"); err != nil || string(b) != "hot\n" {
+		t.Errorf("hot file content %q, error %v", b, err)
+	}
+
+	cold := fs.Names("cold/")
+	if len(cold) != 1 || filepath.Ext(cold[0]) != "."+logwriter.CompressedColdFileExtension {
+		t.Fatalf("cold files %q", cold)
+	}
+
+	b, _ := fs.ReadFile(cold[0])
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := ioutil.ReadAll(zr); err != nil || string(b) != "frozen\n" {
+		t.Errorf("cold file content %q, error %v", b, err)
+	}
+}
+
+func TestFreezeNameCollision(t *testing.T) {
+
+	for _, compress := range []bool{false, true} {
+		fs := logwritertest.NewMemFS()
+		clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, time.UTC))
+
+		// cold file left by previous run must survive
+		f, err := fs.OpenFile("cold/mem-20171127-100000.000000.log", os.O_WRONLY|os.O_CREATE, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte("old\n"))
+		f.Close()
+
+		lw, err := logwriter.NewLogWriter("mem",
+			&logwriter.Config{HotPath: "hot", ColdPath: "cold", CompressColdFile: compress,
+				FreezeInterval: 100 * time.Millisecond, FS: fs, Clock: clock,
+				Mode: logwriter.ProductionMode}, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// clock stands still, every freeze gets the same name from formatter
+		for i := 0; i < 3; i++ {
+			lw.Write([]byte(strconv.Itoa(i) + "\n"))
+			if err := lw.FreezeHotFile(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := lw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if b, _ := fs.ReadFile("cold/mem-20171127-100000.000000.log"); string(b) != "old\n" {
+			t.Errorf("compress %v: existing cold file overwritten by %q", compress, b)
+		}
+
+		for i := 0; i < 3; i++ {
+			name := "cold/mem-20171127-100000.000000-" + strconv.Itoa(i+1) + ".log"
+			if compress {
+				name += "." + logwriter.CompressedColdFileExtension
+			}
+
+			b, err := fs.ReadFile(name)
+			if err != nil {
+				t.Errorf("compress %v: %v, cold files %q", compress, err, fs.Names("cold/"))
+				continue
+			}
+
+			if compress {
+				zr, err := gzip.NewReader(bytes.NewReader(b))
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, _ = ioutil.ReadAll(zr)
+			}
+
+			if string(b) != strconv.Itoa(i)+"\n" {
+				t.Errorf("compress %v: %s content %q", compress, name, b)
+			}
+		}
+	}
+}
+
+func TestNameTemplate(t *testing.T) {
+
+	start := time.Date(2017, 11, 27, 10, 0, 0, 0, time.Local)
+	end := time.Date(2017, 11, 27, 10, 5, 30, 123456000, time.Local)
+
+	cases := []struct {
+		template string
+		v        logwriter.NameValues
+		name     string
+	}{
+		{"{uid}-{end:%Y%m%d-%H%M%S}.{ext}",
+			logwriter.NameValues{UID: "svc-a", End: end.Truncate(time.Second), Ext: "log"},
+			"svc-a-20171127-100530.log"},
+		{logwriter.DefaultColdNameTemplate,
+			logwriter.NameValues{UID: "svc", End: end, Seq: 2, Ext: "log", Codec: "tz"},
+			"svc-20171127-100530.123456-2.log.tz"},
+		{"{hostname}/{uid}.{pid}.{seq}.{ext}{codec}",
+			logwriter.NameValues{UID: "svc", Hostname: "h1", PID: 42, Seq: 3, Ext: "log"},
+			"h1/svc.42.3.log"},
+		{"{uid}_{start:%y%m%d%H%M}_{end:%s.%L}",
+			logwriter.NameValues{UID: "svc", Start: start, End: end.Truncate(time.Millisecond), Seq: 1},
+			"svc_1711271000_" + strconv.FormatInt(end.Unix(), 10) + ".123-1"},
+	}
+
+	for _, c := range cases {
+		tmpl, err := logwriter.ParseNameTemplate(c.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		name := tmpl.Format(c.v)
+		if name != c.name {
+			t.Errorf("%s: name %q, expected %q", c.template, name, c.name)
+		}
+
+		v, err := tmpl.Parse(name)
+		if err != nil {
+			t.Errorf("%s: %v", c.template, err)
+			continue
+		}
+
+		if !v.Start.Equal(c.v.Start) || !v.End.Equal(c.v.End) {
+			t.Errorf("%s: parsed times %v, %v", c.template, v.Start, v.End)
+		}
+		v.Start, v.End, c.v.Start, c.v.End = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+		if v != c.v {
+			t.Errorf("%s: parsed %+v, expected %+v", c.template, v, c.v)
+		}
+	}
+
+	if _, err := logwriter.ParseNameTemplate(logwriter.DefaultHotNameTemplate); err != nil {
+		t.Error(err)
+	}
+
+	for _, bad := range []string{"{uid", "uid}", "{user}", "{uid:%Y}", "{end:%Q}", "{end:}"} {
+		if _, err := logwriter.ParseNameTemplate(bad); err == nil {
+			t.Errorf("template %q accepted", bad)
+		}
+	}
+
+	tmpl, _ := logwriter.ParseNameTemplate(logwriter.DefaultColdNameTemplate)
+	if _, err := tmpl.Parse("svc.log"); err != logwriter.ErrNameMismatch {
+		t.Errorf("mismatch error %v", err)
+	}
+}
+
+func TestNameTemplateLogWriter(t *testing.T) {
+
+	fs := logwritertest.NewMemFS()
+
+	lw, err := logwriter.NewLogWriter("mem",
+		&logwriter.Config{HotPath: "hot", ColdPath: "cold", CompressColdFile: true,
+			HotNameTemplate: "{hostname}-{uid}.{ext}", ColdNameTemplate: "{uid}.{seq}.{ext}{codec}",
+			FS: fs, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		lw.Write([]byte("frozen\n"))
+		if err := lw.FreezeHotFile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := lw.SetConfig(&logwriter.Config{ColdNameTemplate: "{seq"}); err == nil {
+		t.Error("invalid template accepted")
+	}
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	hostname, _ := os.Hostname()
+	hot := fs.Names("hot/")
+	if expected := "hot/" + hostname + "-mem.log"; len(hot) != 1 || hot[0] != expected {
+		t.Errorf("hot files %q, expected %q", hot, expected)
+	}
+
+	cold := fs.Names("cold/")
+	sort.Strings(cold)
+	if len(cold) != 2 || cold[0] != "cold/mem.0.log.tz" || cold[1] != "cold/mem.1.log.tz" {
+		t.Errorf("cold files %q", cold)
+	}
+
+	for _, bad := range []string{"{nope}", "{uid}-{pid}.{ext}", "{uid}-{yyyy}.{ext}"} {
+		if _, err := logwriter.NewLogWriter("mem", &logwriter.Config{HotNameTemplate: bad, FS: fs}, false, nil); err == nil {
+			t.Errorf("hot name template %q accepted", bad)
+		}
+	}
+}
+
+// racingFS creates file name right after it is checked given times, like another
+// process moving its cold file into the same folder does
+type racingFS struct {
+	logwriter.FS
+	mu     sync.Mutex
+	name   string
+	checks int
+}
+
+func (fs *racingFS) Stat(name string) (os.FileInfo, error) {
+
+	fi, err := fs.FS.Stat(name)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if name == fs.name {
+		if fs.checks--; fs.checks > 0 {
+			return fi, err
+		}
+		fs.name = ""
+		if f, err := fs.FS.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0666); err == nil {
+			f.Write([]byte("other\n"))
+			f.Close()
+		}
+	}
+
+	return fi, err
+}
+
+func TestColdNameRace(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+	// checked by freeze, then by cold file move
+	fs := &racingFS{FS: mem, name: "cold/race.0.log", checks: 2}
+
+	lw, err := logwriter.NewLogWriter("race",
+		&logwriter.Config{HotPath: "hot", ColdPath: "cold", ColdNameTemplate: "{uid}.{seq}.{ext}{codec}",
+			FS: fs, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lw.Write([]byte("frozen\n"))
+	if err := lw.FreezeHotFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// file of other process is not overwritten
+	if b, _ := mem.ReadFile("cold/race.0.log"); string(b) != "other\n" {
+		t.Errorf("other cold file content %q", b)
+	}
+	if b, _ := mem.ReadFile("cold/race.1.log"); string(b) != "frozen\n" {
+		t.Errorf("cold file content %q", b)
+	}
+}
+
+func TestNameTemplateLocation(t *testing.T) {
+
+	fs := logwritertest.NewMemFS()
+
+	// clock location differs from local one
+	zone := time.FixedZone("UTC+13", 13*3600)
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, zone))
+
+	lw, err := logwriter.NewLogWriter("mem",
+		&logwriter.Config{ColdPath: "arch/{yyyy}/{mm}/{dd}", ColdNameTemplate: logwriter.ContentTimeColdNameTemplate,
+			FS: fs, Clock: clock, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lw.Write([]byte("frozen\n"))
+	if err := lw.FreezeHotFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	// cold file is made in background
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := lw.ColdFiles(time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// names round-trip in clock location
+	now := clock.Now()
+	if len(found) != 1 || found[0].Name != "arch/2017/11/27/mem-20171127-100000--20171127-100000.log" ||
+		!found[0].First.Equal(now) {
+		t.Errorf("cold files %+v", found)
+	}
+
+	tmpl, _ := logwriter.ParseNameTemplate(logwriter.DefaultColdNameTemplate)
+	tmpl = tmpl.In(zone)
+	if v, err := tmpl.Parse(tmpl.Format(logwriter.NameValues{UID: "svc", End: now.UTC(), Ext: "log"})); err != nil || !v.End.Equal(now) {
+		t.Errorf("Parse() = %v, %v", v.End, err)
+	}
+}
+
+func TestColdPathTemplate(t *testing.T) {
+
+	fs := logwritertest.NewMemFS()
+	clock := logwritertest.NewClock(time.Date(2017, 11, 26, 10, 0, 0, 0, time.Local))
+
+	// not a cold file, it is kept along with its folder
+	f, err := fs.OpenFile("arch/2017/11/27/notes.txt", os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	lw, err := logwriter.NewLogWriter("mem",
+		&logwriter.Config{HotPath: "hot", ColdPath: "arch/{yyyy}/{mm}/{dd}", ColdDirMode: 0700,
+			ColdMaxAge: 36 * time.Hour, FS: fs, Clock: clock, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, days := range []int{0, 1, 2} {
+		clock.Advance(time.Duration(days) * 24 * time.Hour)
+		lw.Write([]byte("frozen\n"))
+		if err := lw.FreezeHotFile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 26th and 27th are older than 36 hours
+	cold := fs.Names("arch/")
+	expected := []string{"arch/2017/11/27/notes.txt", "arch/2017/11/29/mem-20171129-100000.000000.log"}
+	if fmt.Sprint(cold) != fmt.Sprint(expected) {
+		t.Errorf("cold files %q, expected %q", cold, expected)
+	}
+
+	if _, err := fs.Stat("arch/2017/11/26"); !os.IsNotExist(err) {
+		t.Errorf("empty folder is not removed, %v", err)
+	}
+
+	if fi, err := fs.Stat("arch/2017/11/29"); err != nil || !fi.IsDir() || fi.Mode().Perm() != 0700 {
+		t.Errorf("cold folder %v, error %v", fi, err)
+	}
+}
+
+func TestColdMaxAgeSharedColdPath(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+	fs := logwritertest.NewFaultyFS(mem)
+	clock := logwritertest.NewClock(time.Date(2017, 11, 26, 10, 0, 0, 0, time.Local))
+
+	newWriter := func(uid string) *logwriter.LogWriter {
+		lw, err := logwriter.NewLogWriter(uid,
+			&logwriter.Config{HotPath: "hot", ColdPath: "cold", ColdMaxAge: 36 * time.Hour,
+				FS: fs, Clock: clock, Mode: logwriter.ProductionMode}, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lw
+	}
+
+	errs := newWriter("svc.error")
+	errs.Write([]byte("failed\n"))
+	if err := errs.FreezeHotFile(); err != nil {
+		t.Fatal(err)
+	}
+	if err := errs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lw := newWriter("svc")
+	for _, d := range []time.Duration{0, 48 * time.Hour, time.Minute, time.Minute} {
+		clock.Advance(d)
+		lw.Write([]byte("frozen\n"))
+		if err := lw.FreezeHotFile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// expired file of another uid is kept
+	cold := mem.Names("cold/")
+	expected := []string{"cold/svc-20171128-100000.000000.log", "cold/svc-20171128-100100.000000.log",
+		"cold/svc-20171128-100200.000000.log", "cold/svc.error-20171126-100000.000000.log"}
+	if fmt.Sprint(cold) != fmt.Sprint(expected) {
+		t.Errorf("cold files %q, expected %q", cold, expected)
+	}
+
+	// cold folder is not read on every freeze
+	if n := fs.Calls(logwritertest.OpReadDir); n != 3 {
+		t.Errorf("cold folder read %d times, expected 3", n)
+	}
+}
+
+func TestColdMaxAgeTrace(t *testing.T) {
+
+	fs := logwritertest.NewMemFS()
+	clock := logwritertest.NewClock(time.Date(2017, 11, 26, 10, 0, 0, 0, time.Local))
+
+	for i := 0; i < 2; i++ {
+		// trace files expire, parent files are kept forever. Hot trace file of
+		// previous run is frozen on restart
+		lw, err := logwriter.NewLogWriter("svc",
+			&logwriter.Config{HotPath: "hot", ColdPath: "cold", Trace: &logwriter.Config{ColdMaxAge: time.Hour},
+				FS: fs, Clock: clock, Mode: logwriter.ProductionMode}, true, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lw.Write([]byte("frozen\n"))
+		lw.WriteTrace([]byte("traced\n"))
+		if err := lw.FreezeHotFile(); err != nil {
+			t.Fatal(err)
+		}
+		if err := lw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		clock.Advance(2 * time.Hour)
+	}
+
+	cold := fs.Names("cold/")
+	expected := []string{"cold/svc-20171126-100000.000000.log", "cold/svc-20171126-120000.000000.log",
+		"cold/svc-20171126-120000.000000.trc"}
+	if fmt.Sprint(cold) != fmt.Sprint(expected) {
+		t.Errorf("cold files %q, expected %q", cold, expected)
+	}
+}
+
+func TestContentTimeNames(t *testing.T) {
+
+	fs := logwritertest.NewMemFS()
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, time.Local))
+
+	lw, err := logwriter.NewLogWriter("mem",
+		&logwriter.Config{HotPath: "hot", ColdPath: "arch", ColdNameTemplate: logwriter.ContentTimeColdNameTemplate,
+			FS: fs, Clock: clock, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// written at 10:00:00 - 10:00:05 and 10:01:15 - 10:01:17
+	for _, steps := range [][]time.Duration{{0, 5 * time.Second, 10 * time.Second}, {time.Minute, 2 * time.Second, time.Second}} {
+		for i, d := range steps {
+			clock.Advance(d)
+			if i < len(steps)-1 {
+				lw.Write([]byte("item\n"))
+			}
+		}
+
+		if err := lw.FreezeHotFile(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"arch/mem-20171127-100000--20171127-100005.log", "arch/mem-20171127-100115--20171127-100117.log"}
+	if cold := fs.Names("arch/"); fmt.Sprint(cold) != fmt.Sprint(expected) {
+		t.Fatalf("cold files %q, expected %q", cold, expected)
+	}
+
+	at := func(sec int, nsec int) time.Time {
+		return time.Date(2017, 11, 27, 10, 0, sec, nsec, time.Local)
+	}
+
+	cases := []struct {
+		from, to time.Time
+		names    []string
+	}{
+		{time.Time{}, time.Time{}, expected},
+		{at(3, 0), at(5, 500000000), expected[:1]},
+		{at(5, 500000000), at(75, 0), expected},
+		{at(6, 0), at(74, 0), nil},
+		{at(76, 0), time.Time{}, expected[1:]},
+	}
+
+	for _, c := range cases {
+		found, err := lw.ColdFiles(c.from, c.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, cf := range found {
+			names = append(names, cf.Name)
+		}
+
+		if fmt.Sprint(names) != fmt.Sprint(c.names) {
+			t.Errorf("files within %v - %v: %q, expected %q", c.from, c.to, names, c.names)
+		}
+	}
+
+	found, err := logwriter.FindColdFiles(fs, "arch", logwriter.ContentTimeColdNameTemplate, "", "", at(0, 0), at(0, 0))
+	if err != nil || len(found) != 1 || !found[0].First.Equal(at(0, 0)) || !found[0].Last.Equal(at(5, 0)) || found[0].UID != "mem" {
+		t.Errorf("found %+v, error %v", found, err)
+	}
+}
+
+func TestColdFilesEndTimeNames(t *testing.T) {
+
+	fs := logwritertest.NewMemFS()
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, time.UTC))
+
+	newWriter := func(uid string) *logwriter.LogWriter {
+		lw, err := logwriter.NewLogWriter(uid,
+			&logwriter.Config{HotPath: "hot", ColdPath: "arch", FS: fs, Clock: clock, Mode: logwriter.ProductionMode}, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lw
+	}
+
+	lw, errs := newWriter("mem"), newWriter("mem.error")
+
+	// frozen at 10:00 and 10:05
+	for i := 0; i < 2; i++ {
+		for _, w := range []*logwriter.LogWriter{lw, errs} {
+			w.Write([]byte("item\n"))
+			if err := w.FreezeHotFile(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		clock.Advance(5 * time.Minute)
+	}
+
+	for _, w := range []*logwriter.LogWriter{lw, errs} {
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// trace file frozen at 10:03 is not a lower bound of the next log file
+	if f, err := fs.OpenFile("arch/mem-20171127-100300.000000.trc", os.O_WRONLY|os.O_CREATE, 0666); err != nil {
+		t.Fatal(err)
+	} else {
+		f.Write([]byte("traced\n"))
+		f.Close()
+	}
+
+	at := func(min int) time.Time {
+		return time.Date(2017, 11, 27, 10, min, 0, 0, time.UTC)
+	}
+
+	// items of the second file are written after the first one frozen
+	cases := []struct {
+		from, to time.Time
+		names    []string
+	}{
+		{time.Time{}, time.Time{}, []string{"arch/mem-20171127-100000.000000.log", "arch/mem-20171127-100500.000000.log"}},
+		{at(2), at(3), []string{"arch/mem-20171127-100500.000000.log"}},
+		{at(1), at(2), []string{"arch/mem-20171127-100500.000000.log"}},
+		{time.Time{}, at(-1), []string{"arch/mem-20171127-100000.000000.log"}},
+	}
+
+	for _, c := range cases {
+		found, err := lw.ColdFiles(c.from, c.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		for _, cf := range found {
+			names = append(names, cf.Name)
+		}
+
+		if fmt.Sprint(names) != fmt.Sprint(c.names) {
+			t.Errorf("files within %v - %v: %q, expected %q", c.from, c.to, names, c.names)
+		}
+	}
+
+	for ext, n := range map[string]int{"": 3, logwriter.TraceFileExtension: 1} {
+		if found, err := logwriter.FindColdFiles(fs, "arch", "", "mem", ext, time.Time{}, time.Time{}); err != nil || len(found) != n {
+			t.Errorf("found %d files of extension %q, expected %d, error %v", len(found), ext, n, err)
+		}
+	}
+}
+
+func TestContentTimeNamesRestart(t *testing.T) {
+
+	for _, c := range []struct {
+		content string
+		name    string
+	}{
+		// the first item timestamp is lower bound of write times
+		{"2017-11-27T09:00:00Z started\n", "arch/mem-20171127-090000--20171127-100000.log"},
+		// unknown
+		{"started\n", "arch/mem-19700101-000000--20171127-100000.log"},
+	} {
+		fs := logwritertest.NewMemFS()
+		clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, time.UTC))
+
+		// hot file of previous run
+		f, err := fs.OpenFile("hot/mem.log", os.O_WRONLY|os.O_CREATE, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(c.content))
+		f.Close()
+
+		lw, err := logwriter.NewLogWriter("mem",
+			&logwriter.Config{HotPath: "hot", ColdPath: "arch", ColdNameTemplate: logwriter.ContentTimeColdNameTemplate,
+				FS: fs, Clock: clock, Mode: logwriter.ProductionMode}, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lw.Write([]byte("restarted\n"))
+		if err := lw.FreezeHotFile(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := lw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if cold := fs.Names("arch/"); len(cold) != 1 || cold[0] != c.name {
+			t.Errorf("cold files %q, expected %q", cold, c.name)
+		}
+	}
+}
+
+func TestWriteDiskFull(t *testing.T) {
+
+	fs := logwritertest.NewFaultyFS(nil)
+	errs := &logwritertest.ErrorRecorder{}
+
+	lw, err := logwriter.NewLogWriter("full",
+		&logwriter.Config{FS: fs, Mode: logwriter.ProductionMode}, false, errs.Handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs.Inject(logwritertest.Fault{Op: logwritertest.OpWrite, Err: logwritertest.ErrNoSpace, Times: 1})
+
+	if n, err := lw.Write([]byte("lost\n")); n != 0 || !errors.Is(err, logwritertest.ErrNoSpace) {
+		t.Errorf("Write() = %d, %v", n, err)
+	}
+
+	if n, err := lw.Write([]byte("saved\n")); n != 6 || err != nil {
+		t.Errorf("Write() after fault = %d, %v", n, err)
+	}
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs.Count() != 0 {
+		t.Errorf("error handler called on foreground failure: %v", errs.Errors())
+	}
+}
+
+func TestWriteShort(t *testing.T) {
+
+	fs := logwritertest.NewFaultyFS(nil)
+
+	lw, err := logwriter.NewLogWriter("short",
+		&logwriter.Config{FS: fs, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lw.Close()
+
+	fs.Inject(logwritertest.Fault{Op: logwritertest.OpWrite, Short: true})
+
+	if n, err := lw.Write([]byte("12345678")); n != 4 || err != io.ErrShortWrite {
+		t.Errorf("Write() = %d, %v", n, err)
+	}
+}
+
+func TestFlushBufferShort(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+	fs := logwritertest.NewFaultyFS(mem)
+
+	lw, err := logwriter.NewLogWriter("short",
+		&logwriter.Config{BufferSize: logwriter.KB, FS: fs, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lw.Close()
+
+	if _, err := lw.Write([]byte("1234")); err != nil {
+		t.Fatal(err)
+	}
+
+	fs.Inject(logwritertest.Fault{Op: logwritertest.OpWrite, Short: true, Times: 1})
+
+	if err := lw.FlushBuffer(); err != io.ErrShortWrite {
+		t.Errorf("FlushBuffer() = %v", err)
+	}
+
+	if _, err := lw.Write([]byte("5678")); err != nil {
+		t.Fatal(err)
+	}
+	if err := lw.FlushBuffer(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := mem.ReadFile("short.log"); string(b) != "12345678" {
+		t.Errorf("hot file = %q", b)
+	}
+}
+
+func TestFlushByTimerFailure(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+	fs := logwritertest.NewFaultyFS(mem)
+	errs := &logwritertest.ErrorRecorder{}
+	clock := logwritertest.NewClock(time.Date(2017, 11, 27, 10, 0, 0, 0, time.UTC))
+
+	lw, err := logwriter.NewLogWriter("flush",
+		&logwriter.Config{BufferSize: logwriter.KB, BufferFlushInterval: time.Second,
+			FS: fs, Clock: clock, Mode: logwriter.ProductionMode}, false, errs.Handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lw.Write([]byte("buffered\n"))
+
+	fs.Inject(logwritertest.Fault{Op: logwritertest.OpWrite, Err: logwritertest.ErrIO, Times: 2})
+
+	clock.Advance(time.Second)
+	clock.BlockUntil(1)
+
+	if errs.Count() != 1 {
+		t.Fatalf("error handler called %d times, expected 1", errs.Count())
+	}
+
+	// manual flush reports error to the caller, not to the error handler
+	if err := lw.FlushBuffer(); !errors.Is(err, logwritertest.ErrIO) {
+		t.Errorf("FlushBuffer() = %v", err)
+	}
+
+	if errs.Count() != 1 {
+		t.Errorf("error handler called %d times, expected 1", errs.Count())
+	}
+
+	// buffer is kept and persisted once disk is back
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := mem.ReadFile("flush.log"); string(b) != "buffered\n" {
+		t.Errorf("hot file content %q", b)
+	}
+}
+
+func TestFreezeRenameFailure(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+	fs := logwritertest.NewFaultyFS(mem)
+
+	lw, err := logwriter.NewLogWriter("rename",
+		&logwriter.Config{FS: fs, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lw.Write([]byte("first\n"))
+
+	fs.Inject(logwritertest.Fault{Op: logwritertest.OpRename, Err: logwritertest.ErrPermission, Times: 1})
+
+	if err := lw.FreezeHotFile(); !errors.Is(err, logwritertest.ErrPermission) {
+		t.Errorf("FreezeHotFile() = %v", err)
+	}
+
+	// hot file stays usable
+	if _, err := lw.Write([]byte("second\n")); err != nil {
+		t.Errorf("Write() after failed freeze: %v", err)
+	}
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, _ := mem.ReadFile("rename.log"); string(b) != "first\nsecond\n" {
+		t.Errorf("hot file content %q", b)
+	}
+}
+
+func TestCopyFileFailure(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+	fs := logwritertest.NewFaultyFS(mem)
+	errs := &logwritertest.ErrorRecorder{}
+
+	lw, err := logwriter.NewLogWriter("copy",
+		&logwriter.Config{HotPath: "hot", ColdPath: "cold", CompressColdFile: true,
+			FS: fs, Mode: logwriter.ProductionMode}, false, errs.Handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lw.Write([]byte("frozen\n"))
+
+	fs.Inject(logwritertest.Fault{Op: logwritertest.OpWrite,
+		Path: "*." + logwriter.CompressedColdFileExtension, Err: logwritertest.ErrNoSpace})
+
+	// compression runs in background, so freeze itself succeeds
+	if err := lw.FreezeHotFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs.Count() != 1 || !errors.Is(errs.Errors()[0], logwritertest.ErrNoSpace) {
+		t.Fatalf("error handler calls %v", errs.Errors())
+	}
+
+	// partially compressed file removed, frozen content kept in hot folder
+	if cold := mem.Names("cold/"); len(cold) != 0 {
+		t.Errorf("cold files %q", cold)
+	}
+
+	if hot := mem.Names("hot/"); len(hot) != 2 {
+		t.Errorf("hot folder files %q", hot)
+	}
+}
+
 /*
 func TestLogWriter_Write(t *testing.T) {
 
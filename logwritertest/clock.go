@@ -0,0 +1,171 @@
+// Package logwritertest provides helpers for testing code built on top of logwriter
+// without sleeping and without touching real disk where possible.
+package logwritertest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/regorov/logwriter"
+)
+
+// Clock is a logwriter.Clock which time moves only when Advance() or Set() called.
+// Assign it to logwriter.Config.Clock to drive flush and freeze timers manually.
+type Clock struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	now  time.Time
+
+	timers []*timer
+}
+
+// NewClock creates Clock stopped at time t.
+func NewClock(t time.Time) *Clock {
+	c := &Clock{now: t}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns current fake time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	t := c.now
+	c.mu.Unlock()
+	return t
+}
+
+// NewTimer creates timer which fires when clock reaches Now()+d.
+func (c *Clock) NewTimer(d time.Duration) logwriter.Timer {
+	t := &timer{clock: c, c: make(chan time.Time)}
+
+	c.mu.Lock()
+	c.timers = append(c.timers, t)
+	t.arm(c.now.Add(d))
+	c.mu.Unlock()
+
+	return t
+}
+
+// Advance moves clock forward by d and fires every timer with deadline passed, in
+// deadline order. Each expiration is delivered synchronously: Advance returns after
+// the receiver has taken the value from timer channel or the timer has been stopped.
+func (c *Clock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set moves clock to time t. See Advance().
+func (c *Clock) Set(t time.Time) {
+
+	c.mu.Lock()
+	for {
+		var next *timer
+		for _, tm := range c.timers {
+			if tm.active && !tm.deadline.After(t) && (next == nil || tm.deadline.Before(next.deadline)) {
+				next = tm
+			}
+		}
+
+		if next == nil {
+			break
+		}
+
+		if next.deadline.After(c.now) {
+			c.now = next.deadline
+		}
+
+		next.active = false
+		next.cancel = make(chan struct{})
+		c.cond.Broadcast()
+
+		v, cancel := c.now, next.cancel
+		c.mu.Unlock()
+
+		select {
+		case next.c <- v:
+		case <-cancel:
+		}
+
+		c.mu.Lock()
+		if next.cancel == cancel {
+			next.cancel = nil
+		}
+	}
+
+	if t.After(c.now) {
+		c.now = t
+	}
+	c.mu.Unlock()
+}
+
+// BlockUntil blocks until at least n timers are active. Code under test usually
+// rearms its timers after handling expiration, so BlockUntil() called after
+// Advance() waits for this handling to complete.
+func (c *Clock) BlockUntil(n int) {
+	c.mu.Lock()
+	for c.activeTimers() < n {
+		c.cond.Wait()
+	}
+	c.mu.Unlock()
+}
+
+func (c *Clock) activeTimers() int {
+	n := 0
+	for _, t := range c.timers {
+		if t.active {
+			n++
+		}
+	}
+	return n
+}
+
+type timer struct {
+	clock *Clock
+	c     chan time.Time
+
+	deadline time.Time
+	active   bool
+
+	// not nil while expiration is being delivered
+	cancel chan struct{}
+}
+
+// arm must be called with clock.mu locked
+func (t *timer) arm(deadline time.Time) {
+	t.deadline = deadline
+	t.active = true
+	t.clock.cond.Broadcast()
+}
+
+// stop must be called with clock.mu locked
+func (t *timer) stop() bool {
+	wasActive := t.active
+	t.active = false
+
+	if t.cancel != nil {
+		// expiration is not received yet
+		close(t.cancel)
+		t.cancel = nil
+		wasActive = true
+	}
+
+	return wasActive
+}
+
+func (t *timer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *timer) Stop() bool {
+	t.clock.mu.Lock()
+	ok := t.stop()
+	t.clock.mu.Unlock()
+	return ok
+}
+
+func (t *timer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	ok := t.stop()
+	t.arm(t.clock.now.Add(d))
+	t.clock.mu.Unlock()
+	return ok
+}
@@ -0,0 +1,342 @@
+package logwritertest
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/regorov/logwriter"
+)
+
+// Typical i/o errors to inject.
+var (
+	// ErrNoSpace is returned by disk without free space
+	ErrNoSpace error = syscall.ENOSPC
+
+	// ErrIO is low level i/o error
+	ErrIO error = syscall.EIO
+
+	// ErrPermission is returned by operation not permitted
+	ErrPermission error = syscall.EACCES
+)
+
+// Op identifies FS or File operation for fault injection.
+type Op int
+
+// Operations where fault can be injected
+const (
+	OpOpen Op = iota
+	OpRead
+	OpWrite
+	OpClose
+	OpRename
+	OpRemove
+	OpStat
+	OpReadDir
+	OpSync
+	OpSyncDir
+	OpMkdir
+)
+
+var opNames = [...]string{"open", "read", "write", "close", "rename", "remove", "stat", "readdir", "sync", "syncdir", "mkdir"}
+
+func (op Op) String() string {
+	if op < 0 || int(op) >= len(opNames) {
+		return "unknown"
+	}
+	return opNames[op]
+}
+
+// Fault describes failure injected into matching operations.
+type Fault struct {
+	// Operation to fail
+	Op Op
+
+	// Path is filepath.Match pattern matched against base name of the file
+	// (source file for OpRename). Empty Path matches any file
+	Path string
+
+	// After is a number of matching operations passing before fault applies
+	After int
+
+	// Times limits number of failures. Fault applies forever if Times == 0
+	Times int
+
+	// Err returned by failed operation. Defaults to ErrIO, or io.ErrShortWrite if Short
+	Err error
+
+	// Short makes OpWrite to write only half of data before returning error
+	Short bool
+
+	// Delay slows down operation. Operation is not failed if Err is nil and Short is false
+	Delay time.Duration
+}
+
+type faultState struct {
+	Fault
+	seen   int
+	failed int
+}
+
+// FaultyFS wraps logwriter.FS and fails operations in accordance with injected
+// faults. Use it to test reaction on disk full, permission denied, etc.
+type FaultyFS struct {
+	fs logwriter.FS
+
+	mu     sync.Mutex
+	faults []*faultState
+	calls  map[Op]int
+}
+
+// NewFaultyFS wraps fs. MemFS is used if fs is nil.
+func NewFaultyFS(fs logwriter.FS) *FaultyFS {
+	if fs == nil {
+		fs = NewMemFS()
+	}
+	return &FaultyFS{fs: fs, calls: make(map[Op]int)}
+}
+
+// Inject adds fault. Faults are checked in order of injection.
+func (f *FaultyFS) Inject(fault Fault) {
+	f.mu.Lock()
+	f.faults = append(f.faults, &faultState{Fault: fault})
+	f.mu.Unlock()
+}
+
+// Reset removes all injected faults.
+func (f *FaultyFS) Reset() {
+	f.mu.Lock()
+	f.faults = nil
+	f.mu.Unlock()
+}
+
+// Calls returns number of op calls made, including failed ones.
+func (f *FaultyFS) Calls(op Op) int {
+	f.mu.Lock()
+	n := f.calls[op]
+	f.mu.Unlock()
+	return n
+}
+
+// Failures returns number of op calls failed by injected faults.
+func (f *FaultyFS) Failures(op Op) int {
+	f.mu.Lock()
+	n := 0
+	for _, fs := range f.faults {
+		if fs.Op == op {
+			n += fs.failed
+		}
+	}
+	f.mu.Unlock()
+	return n
+}
+
+// check registers op call and returns fault to apply or nil
+func (f *FaultyFS) check(op Op, name string) *Fault {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls[op]++
+
+	for _, fs := range f.faults {
+		if fs.Op != op {
+			continue
+		}
+
+		if fs.Path != "" {
+			if ok, _ := filepath.Match(fs.Path, filepath.Base(name)); !ok {
+				continue
+			}
+		}
+
+		fs.seen++
+		if fs.seen <= fs.After || (fs.Times > 0 && fs.failed >= fs.Times) {
+			continue
+		}
+
+		if fs.Err != nil || fs.Short {
+			fs.failed++
+		}
+
+		fault := fs.Fault
+		return &fault
+	}
+
+	return nil
+}
+
+// apply sleeps if required and returns error to fail operation with or nil
+func (fault *Fault) apply(op Op, name string) error {
+
+	if fault == nil {
+		return nil
+	}
+
+	if fault.Delay > 0 {
+		time.Sleep(fault.Delay)
+	}
+
+	if fault.Err == nil && !fault.Short {
+		return nil
+	}
+
+	err := fault.Err
+	if err == nil {
+		if fault.Short {
+			err = io.ErrShortWrite
+		} else {
+			err = ErrIO
+		}
+	}
+
+	if err == io.ErrShortWrite {
+		return err
+	}
+
+	return &os.PathError{Op: op.String(), Path: name, Err: err}
+}
+
+// OpenFile opens file of underlying FS.
+func (f *FaultyFS) OpenFile(name string, flag int, perm os.FileMode) (logwriter.File, error) {
+
+	if err := f.check(OpOpen, name).apply(OpOpen, name); err != nil {
+		return nil, err
+	}
+
+	file, err := f.fs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &faultyFile{File: file, fs: f}, nil
+}
+
+// Rename renames file of underlying FS.
+func (f *FaultyFS) Rename(oldname, newname string) error {
+	if err := f.check(OpRename, oldname).apply(OpRename, oldname); err != nil {
+		return err
+	}
+	return f.fs.Rename(oldname, newname)
+}
+
+// Remove removes file of underlying FS.
+func (f *FaultyFS) Remove(name string) error {
+	if err := f.check(OpRemove, name).apply(OpRemove, name); err != nil {
+		return err
+	}
+	return f.fs.Remove(name)
+}
+
+// Stat describes file of underlying FS.
+func (f *FaultyFS) Stat(name string) (os.FileInfo, error) {
+	if err := f.check(OpStat, name).apply(OpStat, name); err != nil {
+		return nil, err
+	}
+	return f.fs.Stat(name)
+}
+
+// ReadDir reads folder of underlying FS.
+func (f *FaultyFS) ReadDir(name string) ([]os.FileInfo, error) {
+	if err := f.check(OpReadDir, name).apply(OpReadDir, name); err != nil {
+		return nil, err
+	}
+	return f.fs.ReadDir(name)
+}
+
+// SyncDir syncs folder of underlying FS.
+func (f *FaultyFS) SyncDir(name string) error {
+	if err := f.check(OpSyncDir, name).apply(OpSyncDir, name); err != nil {
+		return err
+	}
+	return f.fs.SyncDir(name)
+}
+
+// MkdirAll creates folder in underlying FS.
+func (f *FaultyFS) MkdirAll(name string, perm os.FileMode) error {
+	if err := f.check(OpMkdir, name).apply(OpMkdir, name); err != nil {
+		return err
+	}
+	return f.fs.MkdirAll(name, perm)
+}
+
+type faultyFile struct {
+	logwriter.File
+	fs *FaultyFS
+}
+
+func (f *faultyFile) Read(p []byte) (int, error) {
+	if err := f.fs.check(OpRead, f.Name()).apply(OpRead, f.Name()); err != nil {
+		return 0, err
+	}
+	return f.File.Read(p)
+}
+
+func (f *faultyFile) Write(p []byte) (int, error) {
+
+	fault := f.fs.check(OpWrite, f.Name())
+
+	err := fault.apply(OpWrite, f.Name())
+	if err == nil {
+		return f.File.Write(p)
+	}
+
+	if !fault.Short {
+		return 0, err
+	}
+
+	n, werr := f.File.Write(p[:len(p)/2])
+	if werr != nil {
+		return n, werr
+	}
+	return n, err
+}
+
+func (f *faultyFile) Sync() error {
+	if err := f.fs.check(OpSync, f.Name()).apply(OpSync, f.Name()); err != nil {
+		return err
+	}
+	return f.File.Sync()
+}
+
+func (f *faultyFile) Close() error {
+	if err := f.fs.check(OpClose, f.Name()).apply(OpClose, f.Name()); err != nil {
+		// release underlying file anyway, like os.File does
+		_ = f.File.Close()
+		return err
+	}
+	return f.File.Close()
+}
+
+// ErrorRecorder collects errors passed to LogWriter error handler. Pass its method
+// Handle to logwriter.NewLogWriter() or SetErrorFunc().
+type ErrorRecorder struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+// Handle records err.
+func (r *ErrorRecorder) Handle(err error) {
+	r.mu.Lock()
+	r.errs = append(r.errs, err)
+	r.mu.Unlock()
+}
+
+// Count returns number of recorded errors.
+func (r *ErrorRecorder) Count() int {
+	r.mu.Lock()
+	n := len(r.errs)
+	r.mu.Unlock()
+	return n
+}
+
+// Errors returns copy of recorded errors.
+func (r *ErrorRecorder) Errors() []error {
+	r.mu.Lock()
+	errs := append([]error(nil), r.errs...)
+	r.mu.Unlock()
+	return errs
+}
@@ -0,0 +1,353 @@
+package logwritertest
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/regorov/logwriter"
+)
+
+// MemFS is in-memory logwriter.FS. Directories are implicit: any path is a valid
+// folder, so there is no need to create HotPath and ColdPath. Folders created by
+// MkdirAll() exist until removed, other ones exist while they hold files.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string]*memFile
+	dirs  map[string]os.FileMode
+}
+
+// NewMemFS creates empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(map[string]*memFile), dirs: make(map[string]os.FileMode)}
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+	mode    os.FileMode
+}
+
+// OpenFile opens or creates in-memory file.
+func (fs *MemFS) OpenFile(name string, flag int, perm os.FileMode) (logwriter.File, error) {
+
+	key := filepath.Clean(name)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	mf, ok := fs.files[key]
+	switch {
+	case ok && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case !ok && flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	case !ok:
+		mf = &memFile{modTime: time.Now(), mode: perm}
+		fs.files[key] = mf
+	}
+
+	if flag&os.O_TRUNC != 0 {
+		mf.data = nil
+	}
+
+	return &memHandle{fs: fs, file: mf, name: name, flag: flag}, nil
+}
+
+// Rename moves file, replacing newname if exists.
+func (fs *MemFS) Rename(oldname, newname string) error {
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	mf, ok := fs.files[filepath.Clean(oldname)]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: os.ErrNotExist}
+	}
+
+	delete(fs.files, filepath.Clean(oldname))
+	fs.files[filepath.Clean(newname)] = mf
+	return nil
+}
+
+// Remove removes file or empty folder.
+func (fs *MemFS) Remove(name string) error {
+
+	key := filepath.Clean(name)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if _, ok := fs.files[key]; ok {
+		delete(fs.files, key)
+		return nil
+	}
+
+	if len(fs.children(key)) > 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+	}
+
+	if _, ok := fs.dirs[key]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+
+	delete(fs.dirs, key)
+	return nil
+}
+
+// Stat describes file.
+func (fs *MemFS) Stat(name string) (os.FileInfo, error) {
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	key := filepath.Clean(name)
+
+	if mf, ok := fs.files[key]; ok {
+		return mf.info(filepath.Base(name)), nil
+	}
+
+	if fs.isDir(key) {
+		return fs.dirInfo(key), nil
+	}
+
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+// ReadDir lists files and folders located directly in folder name.
+func (fs *MemFS) ReadDir(name string) ([]os.FileInfo, error) {
+
+	dir := filepath.Clean(name)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var res []os.FileInfo
+	for _, key := range fs.children(dir) {
+		if mf, ok := fs.files[key]; ok {
+			res = append(res, mf.info(filepath.Base(key)))
+		} else {
+			res = append(res, fs.dirInfo(key))
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Name() < res[j].Name() })
+	return res, nil
+}
+
+// SyncDir does nothing, MemFS has no stable storage.
+func (fs *MemFS) SyncDir(name string) error {
+	return nil
+}
+
+// MkdirAll creates folder name and its parents.
+func (fs *MemFS) MkdirAll(name string, perm os.FileMode) error {
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	for dir := filepath.Clean(name); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if _, ok := fs.files[dir]; ok {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+		if _, ok := fs.dirs[dir]; !ok {
+			fs.dirs[dir] = perm
+		}
+	}
+
+	return nil
+}
+
+// children returns sorted names of files and folders located directly in folder dir.
+// Must be called with fs.mu locked
+func (fs *MemFS) children(dir string) []string {
+
+	found := make(map[string]bool)
+	add := func(key string) {
+		for ; key != filepath.Dir(key); key = filepath.Dir(key) {
+			if filepath.Dir(key) == dir {
+				found[key] = true
+				return
+			}
+		}
+	}
+
+	for key := range fs.files {
+		add(key)
+	}
+	for key := range fs.dirs {
+		add(key)
+	}
+
+	res := make([]string, 0, len(found))
+	for key := range found {
+		res = append(res, key)
+	}
+
+	sort.Strings(res)
+	return res
+}
+
+// isDir must be called with fs.mu locked
+func (fs *MemFS) isDir(key string) bool {
+	if _, ok := fs.dirs[key]; ok {
+		return true
+	}
+	return key == filepath.Dir(key) || len(fs.children(key)) > 0
+}
+
+// dirInfo must be called with fs.mu locked
+func (fs *MemFS) dirInfo(key string) os.FileInfo {
+	perm, ok := fs.dirs[key]
+	if !ok {
+		perm = 0777
+	}
+	return &memFileInfo{name: filepath.Base(key), mode: os.ModeDir | perm, dir: true}
+}
+
+// ReadFile returns copy of file content.
+func (fs *MemFS) ReadFile(name string) ([]byte, error) {
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	mf, ok := fs.files[filepath.Clean(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "read", Path: name, Err: os.ErrNotExist}
+	}
+
+	return append([]byte(nil), mf.data...), nil
+}
+
+// Names returns sorted names of all files having prefix.
+func (fs *MemFS) Names(prefix string) []string {
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	var res []string
+	for key := range fs.files {
+		if strings.HasPrefix(key, prefix) {
+			res = append(res, key)
+		}
+	}
+
+	sort.Strings(res)
+	return res
+}
+
+// info must be called with fs.mu locked
+func (mf *memFile) info(name string) os.FileInfo {
+	return &memFileInfo{name: name, size: int64(len(mf.data)), mode: mf.mode, modTime: mf.modTime}
+}
+
+type memFileInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+	dir     bool
+}
+
+func (fi *memFileInfo) Name() string       { return fi.name }
+func (fi *memFileInfo) Size() int64        { return fi.size }
+func (fi *memFileInfo) Mode() os.FileMode  { return fi.mode }
+func (fi *memFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *memFileInfo) IsDir() bool        { return fi.dir }
+func (fi *memFileInfo) Sys() interface{}   { return nil }
+
+// memHandle is an open MemFS file
+type memHandle struct {
+	fs     *MemFS
+	file   *memFile
+	name   string
+	flag   int
+	offset int64
+	closed bool
+}
+
+func (h *memHandle) Name() string {
+	return h.name
+}
+
+func (h *memHandle) Read(p []byte) (int, error) {
+
+	h.fs.mu.Lock()
+	defer h.fs.mu.Unlock()
+
+	if h.closed {
+		return 0, os.ErrClosed
+	}
+
+	if h.offset >= int64(len(h.file.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, h.file.data[h.offset:])
+	h.offset += int64(n)
+	return n, nil
+}
+
+func (h *memHandle) Write(p []byte) (int, error) {
+
+	h.fs.mu.Lock()
+	defer h.fs.mu.Unlock()
+
+	if h.closed {
+		return 0, os.ErrClosed
+	}
+
+	if h.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, &os.PathError{Op: "write", Path: h.name, Err: os.ErrPermission}
+	}
+
+	if h.flag&os.O_APPEND != 0 {
+		h.offset = int64(len(h.file.data))
+	}
+
+	if end := h.offset + int64(len(p)); end > int64(len(h.file.data)) {
+		h.file.data = append(h.file.data, make([]byte, end-int64(len(h.file.data)))...)
+	}
+
+	n := copy(h.file.data[h.offset:], p)
+	h.offset += int64(n)
+	h.file.modTime = time.Now()
+	return n, nil
+}
+
+func (h *memHandle) Close() error {
+
+	h.fs.mu.Lock()
+	defer h.fs.mu.Unlock()
+
+	if h.closed {
+		return os.ErrClosed
+	}
+
+	h.closed = true
+	return nil
+}
+
+func (h *memHandle) Sync() error {
+
+	h.fs.mu.Lock()
+	defer h.fs.mu.Unlock()
+
+	if h.closed {
+		return os.ErrClosed
+	}
+	return nil
+}
+
+func (h *memHandle) Stat() (os.FileInfo, error) {
+
+	h.fs.mu.Lock()
+	defer h.fs.mu.Unlock()
+
+	return h.file.info(filepath.Base(h.name)), nil
+}
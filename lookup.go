@@ -0,0 +1,165 @@
+package logwriter
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// ColdFile describes cold file found by FindColdFiles().
+type ColdFile struct {
+	// Name is full file name
+	Name string
+
+	// Token values recovered from the name
+	NameValues
+
+	// From and To bound time of log items in the file. Zero From means the lower
+	// bound is unknown
+	From time.Time
+	To   time.Time
+}
+
+// ColdFiles returns cold files of LogWriter holding log items written within [from, to],
+// see FindColdFiles().
+func (lw *LogWriter) ColdFiles(from, to time.Time) ([]ColdFile, error) {
+
+	lw.RLock()
+	fs, t, coldPath, uid, ext := lw.fs, lw.coldTemplate, lw.config.ColdPath, lw.uid, lw.coldFileExtension
+	lw.RUnlock()
+
+	return findColdFiles(fs, t, coldPath, uid, ext, from, to)
+}
+
+// FindColdFiles returns cold files of LogWriter uid (any if empty) having extension ext
+// (any if empty, e.g. ColdFileExtension or TraceFileExtension) located in coldPath
+// (it may be a template) and named by nameTemplate (DefaultColdNameTemplate if empty),
+// holding log items written within [from, to]. Zero from or to leaves range unbounded.
+// Files are not opened, time range of a file is recovered from its name, in local time
+// zone: {first} and {last} times, {start} and {end} ones if absent. If name has no
+// lower bound, {end} of the previous file of the same uid is taken, the oldest file
+// has no lower bound. Precise ranges need ContentTimeColdNameTemplate. Files are
+// sorted by time range end.
+func FindColdFiles(fs FS, coldPath, nameTemplate, uid, ext string, from, to time.Time) ([]ColdFile, error) {
+
+	_, t, _, err := nameTemplates(&Config{ColdPath: coldPath, ColdNameTemplate: nameTemplate})
+	if err != nil {
+		return nil, err
+	}
+
+	return findColdFiles(fsOrDefault(fs), t, coldPath, uid, ext, from, to)
+}
+
+func findColdFiles(fs FS, t *NameTemplate, coldPath, uid, ext string, from, to time.Time) ([]ColdFile, error) {
+
+	content := t.has("first") || t.has("last")
+
+	// name time is truncated to resolution of its format
+	res := t.resolution("end")
+	if content {
+		res = t.resolution("last")
+	}
+
+	var all []ColdFile
+
+	root, depth, _ := coldRoot(coldPath, t)
+	_, err := walkCold(fs, t, root, depth, false, func(name string, fi os.FileInfo, v NameValues) (bool, error) {
+
+		if uid != "" && t.has("uid") && v.UID != uid {
+			// file of another LogWriter sharing ColdPath
+			return false, nil
+		}
+
+		if ext != "" && t.has("ext") && v.Ext != ext {
+			// trace or dump file sharing uid and ColdPath
+			return false, nil
+		}
+
+		cf := ColdFile{Name: name, NameValues: v}
+		if content {
+			cf.From, cf.To = v.First, v.Last
+		} else {
+			cf.From, cf.To = v.Start, v.End
+		}
+
+		all = append(all, cf)
+		return false, nil
+	})
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// truncated times keep order of files
+	sort.SliceStable(all, func(i, j int) bool {
+		if all[i].To.Equal(all[j].To) {
+			return all[i].Seq < all[j].Seq || all[i].Seq == all[j].Seq && all[i].Name < all[j].Name
+		}
+		return all[i].To.Before(all[j].To)
+	})
+
+	var (
+		found []ColdFile
+
+		// truncated upper bound of the previous file by uid
+		prev = make(map[string]time.Time)
+	)
+
+	for _, cf := range all {
+
+		end := cf.To
+		if cf.From.IsZero() {
+			// items are written after the previous file is frozen
+			cf.From = prev[cf.UID]
+		}
+		prev[cf.UID] = end
+
+		if res > 0 && !cf.To.IsZero() {
+			cf.To = cf.To.Add(res - 1)
+		}
+
+		if (to.IsZero() || !cf.From.After(to)) && (from.IsZero() || cf.To.IsZero() || !cf.To.Before(from)) {
+			found = append(found, cf)
+		}
+	}
+
+	return found, nil
+}
+
+// firstItemTime returns lower bound of time log items were written into hot file f
+// of previous run: timestamp of its first item if found, Unix epoch otherwise.
+// The bound is not later than modTime of the file.
+func (lw *LogWriter) firstItemTime(f File, modTime time.Time) time.Time {
+
+	unknown := time.Unix(0, 0)
+
+	r, err := lw.fs.OpenFile(f.Name(), os.O_RDONLY, 0)
+	if err != nil {
+		return unknown
+	}
+	defer r.Close()
+
+	head := make([]byte, parseTimestampLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return unknown
+	}
+
+	head = head[:n]
+	if i := bytes.IndexByte(head, '\n'); i >= 0 {
+		head = head[:i+1]
+	}
+
+	parse := lw.config.Timestamp
+	if parse == nil {
+		parse = ParseTimestamp
+	}
+
+	if ts, ok := parse(head); ok && !ts.After(modTime) {
+		return ts
+	}
+
+	return unknown
+}
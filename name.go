@@ -0,0 +1,586 @@
+package logwriter
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNameMismatch is returned by NameTemplate.Parse() if name is not made by the template.
+var ErrNameMismatch = errors.New("logwriter: name does not match template")
+
+// Default file name templates, see Config.HotNameTemplate and Config.ColdNameTemplate.
+// Cold file names have microseconds, so they are unique at any Config.FreezeInterval
+const (
+	DefaultHotNameTemplate  = "{uid}.{ext}"
+	DefaultColdNameTemplate = "{uid}-{end:%Y%m%d-%H%M%S.%f}.{ext}"
+
+	// ContentTimeColdNameTemplate names cold file by times of the first and the last
+	// log items written into it, see LogWriter.ColdFiles()
+	ContentTimeColdNameTemplate = "{uid}-{first}--{last}.{ext}"
+)
+
+// defaultTimeFormat is used by time tokens without format
+const defaultTimeFormat = "%Y%m%d-%H%M%S"
+
+// NameValues holds values of file name template tokens.
+type NameValues struct {
+	UID      string
+	Hostname string
+	PID      int
+	Seq      int
+	Start    time.Time
+	End      time.Time
+	First    time.Time
+	Last     time.Time
+	Ext      string
+	Codec    string
+}
+
+// NameTemplate makes file names of literal text and tokens in braces:
+//
+//	{uid}       LogWriter uid
+//	{hostname}  host name
+//	{pid}       process id
+//	{seq}       sequence number of cold files having the same name otherwise, starts from 0
+//	{start}     time hot file was created
+//	{end}       time hot file was frozen
+//	{first}     time the first log item was written into hot file
+//	{last}      time the last log item was written into hot file
+//	{ext}       file extension
+//	{codec}     extension of compressed file preceded by dot, empty if file is not compressed
+//	{yyyy}      year of {end}, same as {end:%Y}
+//	{mm}        month of {end}, same as {end:%m}
+//	{dd}        day of {end}, same as {end:%d}
+//	{hh}        hour of {end}, same as {end:%H}
+//
+// Time tokens take strftime-style format after colon, "{end:%Y%m%d-%H%M%S}" is the
+// default one. Times are formatted and parsed in location of template, local time zone
+// unless set by In(). Supported directives are %Y, %y, %m, %d, %H, %M, %S, %L (milliseconds),
+// %f (microseconds), %N (nanoseconds), %s (unix time) and %%.
+//
+// Template without {seq} gets suffix "-N" before ".{ext}" (or at the end) if N > 0.
+// Template without {codec} gets extension of compressed file appended.
+type NameTemplate struct {
+	text  string
+	parts []namePart
+	codec bool
+
+	// location of times in names
+	loc *time.Location
+
+	// matches names made by template, has single group per part having value
+	re *regexp.Regexp
+}
+
+// namePart is literal text or token of NameTemplate
+type namePart struct {
+	// token name, empty for literal text. Hidden sequence suffix is "-seq"
+	token string
+
+	// literal text or time format
+	text string
+
+	// matches time formatted by text, has group per directive
+	timeRe *regexp.Regexp
+
+	// index of part group in NameTemplate.re, 0 if part has no group
+	group int
+}
+
+// nameTokens lists NameTemplate tokens, true if token takes time format
+var nameTokens = map[string]bool{
+	"uid": false, "hostname": false, "pid": false, "seq": false,
+	"start": true, "end": true, "first": true, "last": true, "ext": false, "codec": false,
+	"yyyy": false, "mm": false, "dd": false, "hh": false,
+}
+
+// nameAliases maps shorthand token to time format of {end}
+var nameAliases = map[string]string{"yyyy": "%Y", "mm": "%m", "dd": "%d", "hh": "%H"}
+
+// ParseNameTemplate parses file name template, see NameTemplate.
+func ParseNameTemplate(text string) (*NameTemplate, error) {
+	return parseNameTemplate(text, true)
+}
+
+// parseNameTemplate parses template, hidden sequence suffix is added if seq is true
+func parseNameTemplate(text string, seq bool) (*NameTemplate, error) {
+
+	t := &NameTemplate{text: text, loc: time.Local}
+
+	hasSeq := false
+	for s := text; s != ""; {
+
+		i := strings.IndexAny(s, "{}")
+		if i < 0 {
+			t.parts = append(t.parts, namePart{text: s})
+			break
+		}
+
+		if s[i] == '}' {
+			return nil, fmt.Errorf("logwriter: unexpected '}' in name template %q", text)
+		}
+
+		if i > 0 {
+			t.parts = append(t.parts, namePart{text: s[:i]})
+		}
+		s = s[i+1:]
+
+		j := strings.IndexByte(s, '}')
+		if j < 0 {
+			return nil, fmt.Errorf("logwriter: unclosed '{' in name template %q", text)
+		}
+
+		p := namePart{token: s[:j]}
+		s = s[j+1:]
+
+		if k := strings.IndexByte(p.token, ':'); k >= 0 {
+			p.token, p.text = p.token[:k], p.token[k+1:]
+			if p.text == "" {
+				return nil, fmt.Errorf("logwriter: empty format of {%s} in name template %q", p.token, text)
+			}
+		}
+
+		isTime, ok := nameTokens[p.token]
+		if f, alias := nameAliases[p.token]; alias && p.text == "" {
+			p.token, p.text, isTime = "end", f, true
+		}
+
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("logwriter: unknown token {%s} in name template %q", p.token, text)
+		case p.text != "" && !isTime:
+			return nil, fmt.Errorf("logwriter: token {%s} takes no format in name template %q", p.token, text)
+		case isTime:
+			if p.text == "" {
+				p.text = defaultTimeFormat
+			}
+
+			re, err := timeRegexp(p.text, true)
+			if err != nil {
+				return nil, fmt.Errorf("%v in name template %q", err, text)
+			}
+			p.timeRe = regexp.MustCompile("^" + re + "$")
+		}
+
+		hasSeq = hasSeq || p.token == "seq"
+		t.codec = t.codec || p.token == "codec"
+		t.parts = append(t.parts, p)
+	}
+
+	if seq && !hasSeq {
+		t.insertSeq()
+	}
+
+	t.compile()
+
+	return t, nil
+}
+
+// insertSeq adds hidden sequence suffix before the last ".{ext}", or at the end
+func (t *NameTemplate) insertSeq() {
+
+	at := len(t.parts)
+	if at > 0 && t.parts[at-1].token == "codec" {
+		at--
+	}
+
+	for i := len(t.parts) - 1; i > 0; i-- {
+		if t.parts[i].token == "ext" && t.parts[i-1].token == "" && strings.HasSuffix(t.parts[i-1].text, ".") {
+			// split literal text to have suffix before dot
+			lit := t.parts[i-1].text
+			parts := append([]namePart{}, t.parts[:i-1]...)
+			if len(lit) > 1 {
+				parts = append(parts, namePart{text: lit[:len(lit)-1]})
+			}
+			parts = append(parts, namePart{token: "-seq"}, namePart{text: "."})
+			t.parts = append(parts, t.parts[i:]...)
+			return
+		}
+	}
+
+	t.parts = append(t.parts[:at], append([]namePart{{token: "-seq"}}, t.parts[at:]...)...)
+}
+
+// compile builds regexp matching names made by template
+func (t *NameTemplate) compile() {
+
+	var (
+		b     strings.Builder
+		group int
+		codec = regexp.QuoteMeta(CompressedColdFileExtension)
+	)
+
+	b.WriteString("^")
+	for i := range t.parts {
+		p := &t.parts[i]
+
+		var re string
+		switch p.token {
+		case "":
+			b.WriteString(regexp.QuoteMeta(filepath.ToSlash(p.text)))
+			continue
+		case "uid", "hostname":
+			re = "(.+?)"
+		case "pid", "seq":
+			re = `(\d+)`
+		case "-seq":
+			re = `(?:-(\d+))?`
+		case "start", "end", "first", "last":
+			s, _ := timeRegexp(p.text, false)
+			re = "(" + s + ")"
+		case "ext":
+			re = `([^./\\]*)`
+		case "codec":
+			re = `(?:\.(` + codec + `))?`
+		}
+
+		group++
+		p.group = group
+		b.WriteString(re)
+	}
+
+	if !t.codec {
+		group++
+		b.WriteString(`(?:\.(` + codec + `))?`)
+	}
+	b.WriteString("$")
+
+	t.re = regexp.MustCompile(b.String())
+}
+
+// In returns copy of template formatting and parsing times in location loc.
+func (t *NameTemplate) In(loc *time.Location) *NameTemplate {
+	c := *t
+	c.loc = loc
+	return &c
+}
+
+// String returns template text.
+func (t *NameTemplate) String() string {
+	return t.text
+}
+
+// Format makes file name of v.
+func (t *NameTemplate) Format(v NameValues) string {
+
+	var b strings.Builder
+	for _, p := range t.parts {
+		switch p.token {
+		case "":
+			b.WriteString(p.text)
+		case "uid":
+			b.WriteString(v.UID)
+		case "hostname":
+			b.WriteString(v.Hostname)
+		case "pid":
+			b.WriteString(strconv.Itoa(v.PID))
+		case "seq":
+			b.WriteString(strconv.Itoa(v.Seq))
+		case "-seq":
+			if v.Seq > 0 {
+				b.WriteString("-" + strconv.Itoa(v.Seq))
+			}
+		case "start":
+			b.WriteString(formatTime(p.text, t.in(v.Start)))
+		case "end":
+			b.WriteString(formatTime(p.text, t.in(v.End)))
+		case "first":
+			b.WriteString(formatTime(p.text, t.in(v.First)))
+		case "last":
+			b.WriteString(formatTime(p.text, t.in(v.Last)))
+		case "ext":
+			b.WriteString(v.Ext)
+		case "codec":
+			if v.Codec != "" {
+				b.WriteString("." + v.Codec)
+			}
+		}
+	}
+
+	if !t.codec && v.Codec != "" {
+		b.WriteString("." + v.Codec)
+	}
+
+	return b.String()
+}
+
+// Parse recovers token values from name made by template. Leading directories of name
+// not covered by template are ignored.
+// Times are parsed in location of template, fields missing in time format are zero.
+// Codec is recognized if it is CompressedColdFileExtension.
+func (t *NameTemplate) Parse(name string) (NameValues, error) {
+
+	var v NameValues
+
+	m := t.re.FindStringSubmatch(t.trim(filepath.ToSlash(name)))
+	if m == nil {
+		return v, ErrNameMismatch
+	}
+
+	for _, p := range t.parts {
+		if p.group == 0 {
+			continue
+		}
+
+		s := m[p.group]
+		switch p.token {
+		case "uid":
+			v.UID = s
+		case "hostname":
+			v.Hostname = s
+		case "pid":
+			v.PID, _ = strconv.Atoi(s)
+		case "seq", "-seq":
+			if s != "" {
+				v.Seq, _ = strconv.Atoi(s)
+			}
+		case "start":
+			v.Start = parseTime(p, s, t.loc)
+		case "end":
+			v.End = parseTime(p, s, t.loc)
+		case "first":
+			v.First = parseTime(p, s, t.loc)
+		case "last":
+			v.Last = parseTime(p, s, t.loc)
+		case "ext":
+			v.Ext = s
+		case "codec":
+			v.Codec = s
+		}
+	}
+
+	if !t.codec {
+		v.Codec = m[len(m)-1]
+	}
+
+	return v, nil
+}
+
+// in converts tm into location of template, zero time is kept as is
+func (t *NameTemplate) in(tm time.Time) time.Time {
+	if tm.IsZero() {
+		return tm
+	}
+	return tm.In(t.loc)
+}
+
+// has returns true if template has token
+func (t *NameTemplate) has(token string) bool {
+	for _, p := range t.parts {
+		if p.token == token {
+			return true
+		}
+	}
+	return false
+}
+
+// resolution returns time resolution of the finest format of time token, 0 if
+// template has no such token
+func (t *NameTemplate) resolution(token string) time.Duration {
+
+	var res time.Duration
+	for _, p := range t.parts {
+		if p.token != token {
+			continue
+		}
+
+		for i := 0; i+1 < len(p.text); i++ {
+			if p.text[i] != '%' {
+				continue
+			}
+
+			i++
+			if d := timeResolutions[p.text[i]]; d > 0 && (res == 0 || d < res) {
+				res = d
+			}
+		}
+	}
+
+	return res
+}
+
+// trim removes leading directories of name not covered by template
+func (t *NameTemplate) trim(name string) string {
+
+	dirs := 0
+	for _, p := range t.parts {
+		if p.token == "" {
+			dirs += strings.Count(filepath.ToSlash(p.text), "/")
+		}
+	}
+
+	for i := len(name) - 1; i >= 0; i-- {
+		if name[i] == '/' {
+			if dirs == 0 {
+				return name[i+1:]
+			}
+			dirs--
+		}
+	}
+
+	return name
+}
+
+// timeDirectives maps strftime-style directive to width of its value, 0 for variable width
+var timeDirectives = map[byte]int{
+	'Y': 4, 'y': 2, 'm': 2, 'd': 2, 'H': 2, 'M': 2, 'S': 2,
+	'L': 3, 'f': 6, 'N': 9, 's': 0,
+}
+
+// timeResolutions maps strftime-style directive to time unit it gives
+var timeResolutions = map[byte]time.Duration{
+	'Y': 366 * 24 * time.Hour, 'y': 366 * 24 * time.Hour, 'm': 31 * 24 * time.Hour,
+	'd': 24 * time.Hour, 'H': time.Hour, 'M': time.Minute, 'S': time.Second,
+	'L': time.Millisecond, 'f': time.Microsecond, 'N': time.Nanosecond, 's': time.Second,
+}
+
+// timeRegexp returns regexp matching time formatted by format, with group per
+// directive if groups is true
+func timeRegexp(format string, groups bool) (string, error) {
+
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' {
+			b.WriteString(regexp.QuoteMeta(string(c)))
+			continue
+		}
+
+		i++
+		if i == len(format) {
+			return "", fmt.Errorf("logwriter: incomplete time directive in %q", format)
+		}
+
+		if format[i] == '%' {
+			b.WriteString("%")
+			continue
+		}
+
+		width, ok := timeDirectives[format[i]]
+		if !ok {
+			return "", fmt.Errorf("logwriter: unknown time directive %%%c", format[i])
+		}
+
+		re := `\d+`
+		if width > 0 {
+			re = `\d{` + strconv.Itoa(width) + `}`
+		}
+		if groups {
+			re = "(" + re + ")"
+		}
+		b.WriteString(re)
+	}
+
+	return b.String(), nil
+}
+
+// formatTime formats t by strftime-style format
+func formatTime(format string, t time.Time) string {
+
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' || i+1 == len(format) {
+			b.WriteByte(c)
+			continue
+		}
+
+		i++
+		switch format[i] {
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case 'y':
+			fmt.Fprintf(&b, "%02d", t.Year()%100)
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 'L':
+			fmt.Fprintf(&b, "%03d", t.Nanosecond()/int(time.Millisecond))
+		case 'f':
+			fmt.Fprintf(&b, "%06d", t.Nanosecond()/int(time.Microsecond))
+		case 'N':
+			fmt.Fprintf(&b, "%09d", t.Nanosecond())
+		case 's':
+			b.WriteString(strconv.FormatInt(t.Unix(), 10))
+		default:
+			b.WriteByte(format[i])
+		}
+	}
+
+	return b.String()
+}
+
+// parseTime parses time s formatted by time token p in location loc
+func parseTime(p namePart, s string, loc *time.Location) time.Time {
+
+	m := p.timeRe.FindStringSubmatch(s)
+	if m == nil {
+		return time.Time{}
+	}
+
+	var (
+		year, day      = 1, 1
+		month          = time.January
+		hour, min, sec int
+		nsec           int
+		unix           = int64(-1)
+		n              = 1
+		format         = p.text
+	)
+
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+
+		i++
+		if format[i] == '%' {
+			continue
+		}
+
+		v, _ := strconv.Atoi(m[n])
+		switch format[i] {
+		case 'Y':
+			year = v
+		case 'y':
+			year = 2000 + v
+		case 'm':
+			month = time.Month(v)
+		case 'd':
+			day = v
+		case 'H':
+			hour = v
+		case 'M':
+			min = v
+		case 'S':
+			sec = v
+		case 'L':
+			nsec = v * int(time.Millisecond)
+		case 'f':
+			nsec = v * int(time.Microsecond)
+		case 'N':
+			nsec = v
+		case 's':
+			unix, _ = strconv.ParseInt(m[n], 10, 64)
+		}
+		n++
+	}
+
+	if unix >= 0 {
+		return time.Unix(unix, int64(nsec)).In(loc)
+	}
+
+	return time.Date(year, month, day, hour, min, sec, nsec, loc)
+}
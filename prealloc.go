@@ -0,0 +1,43 @@
+package logwriter
+
+import (
+	"os"
+)
+
+// preallocFile is a hot file having disk space allocated in advance beyond its size.
+// Preallocation keeps file size, so hot file left by crashed process holds written
+// data only and is reopened as is. Unused space is released on Close().
+type preallocFile struct {
+	*os.File
+}
+
+// preallocate allocates size bytes of disk space for file f
+func preallocate(f *os.File, size int64) File {
+
+	// preallocation is an optimization, file works without it
+	_ = fallocate(f, size)
+
+	return &preallocFile{File: f}
+}
+
+// osFile returns underlying file, so writev is used for it
+func (f *preallocFile) osFile() *os.File {
+	return f.File
+}
+
+// Close releases preallocated space and closes file
+func (f *preallocFile) Close() error {
+
+	fi, err := f.File.Stat()
+	if err == nil {
+		// space beyond file size is released by truncate
+		err = f.File.Truncate(fi.Size())
+	}
+
+	if err != nil {
+		_ = f.File.Close()
+		return err
+	}
+
+	return f.File.Close()
+}
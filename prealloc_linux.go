@@ -0,0 +1,17 @@
+//go:build linux
+// +build linux
+
+package logwriter
+
+import (
+	"os"
+	"syscall"
+)
+
+// FALLOC_FL_KEEP_SIZE mode of fallocate(2)
+const fallocKeepSize = 0x01
+
+// fallocate allocates disk space for f up to size bytes, file size is not changed
+func fallocate(f *os.File, size int64) error {
+	return syscall.Fallocate(int(f.Fd()), fallocKeepSize, 0, size)
+}
@@ -0,0 +1,18 @@
+//go:build linux
+// +build linux
+
+package logwriter_test
+
+import (
+	"os"
+	"syscall"
+)
+
+// allocatedSize returns disk space allocated for file fi, ok is false if unknown
+func allocatedSize(fi os.FileInfo) (int64, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return st.Blocks * 512, true
+}
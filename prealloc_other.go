@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package logwriter
+
+import "os"
+
+// fallocate does nothing, preallocation is supported on Linux only
+func fallocate(f *os.File, size int64) error {
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package logwriter_test
+
+import "os"
+
+// allocatedSize is unknown, preallocation is supported on Linux only
+func allocatedSize(fi os.FileInfo) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,170 @@
+package logwriter
+
+import "bytes"
+
+// RecordMode defines what is a log record for LogWriter. Buffer flushes and hot file
+// freezes happen on record boundaries only, so a record never spans two files.
+type RecordMode int
+
+// Supported record modes
+const (
+	// WriteRecords treats every Write() call as a record. It fits log.Logger and most of
+	// logging packages making single Write() per log item
+	WriteRecords RecordMode = 0
+
+	// LineRecords treats every newline terminated line as a record. Incomplete line is kept
+	// in memory until its end arrives, so it is not visible in hot file and FlushBuffer()
+	// does not persist it. It is written as is by Close() or if it grows over maxPartialLen
+	// (64 KB or BufferSize if bigger). The rest of such oversized line is written by
+	// the following calls, so hot file could be frozen between parts of the line
+	LineRecords RecordMode = 1
+
+	// GroupRecords treats line and following continuation lines (see Config.Continuation)
+	// as a record, e.g. error message and its stack trace. Group is kept in memory until
+	// next non-continuation line arrives. Complete lines of the group are written by Close(),
+	// by FreezeAfterIdle, by buffer flush timer if there were no writes since previous tick,
+	// or if the group grows over maxPartialLen. Group written that way could be split
+	// by hot file freeze like oversized line of LineRecords
+	GroupRecords RecordMode = 2
+)
+
+// maxPartialLen limits incomplete record kept in memory, if config.BufferSize is smaller
+const maxPartialLen = 64 * KB
+
+// writeRecords passes complete records of p into write() and keeps the rest in
+// lw.partial. Must be called with lw locked.
+func (lw *LogWriter) writeRecords(p []byte) (int, error) {
+
+	from := len(lw.partial)
+	lw.partialIdle = false
+
+	data := p
+	if from > 0 {
+		data = append(lw.partial, p...)
+	}
+
+	end := lw.recordsEnd(data, from)
+	if end == 0 && len(data) >= lw.maxPartialLen() {
+		// there is no reason to wait any longer
+		end = lw.linesEnd(data)
+	}
+
+	if end == 0 {
+		if from > 0 {
+			lw.partial = data
+		} else {
+			lw.partial = append(lw.partial[:0], p...)
+		}
+		return len(p), nil
+	}
+
+	if n, err := lw.write(data[:end]); err != nil {
+		switch {
+		case n < from:
+			// kept bytes are accepted by previous calls already, keep the rest of them
+			lw.partial = append(lw.partial[:0], data[n:from]...)
+			return 0, err
+		case n < end:
+			lw.partial = lw.partial[:0]
+			return n - from, err
+		}
+
+		// written, but failed afterwards (sync, freeze)
+		lw.partial = append(lw.partial[:0], data[end:]...)
+		return len(p), err
+	}
+
+	// data may share memory with lw.partial, but data[:end] is written already
+	lw.partial = append(lw.partial[:0], data[end:]...)
+
+	return len(p), nil
+}
+
+// recordsEnd returns length of complete records in data. Bytes before from
+// were checked by previous calls.
+func (lw *LogWriter) recordsEnd(data []byte, from int) int {
+
+	if lw.config.Records == LineRecords {
+		if i := bytes.LastIndexByte(data[from:], '\n'); i >= 0 {
+			return from + i + 1
+		}
+		return 0
+	}
+
+	// GroupRecords. Start from the last incomplete line of previous data
+	end := 0
+	start := bytes.LastIndexByte(data[:from], '\n') + 1
+
+	for {
+		i := bytes.IndexByte(data[start:], '\n')
+		if i < 0 {
+			return end
+		}
+
+		if start > 0 && !lw.isContinuation(data[start:start+i]) {
+			// new group starts here
+			end = start
+		}
+
+		start += i + 1
+	}
+}
+
+// linesEnd returns length of complete lines in data or len(data) if there is no
+// complete line for GroupRecords. Returns len(data) for LineRecords.
+func (lw *LogWriter) linesEnd(data []byte) int {
+	if lw.config.Records == GroupRecords {
+		if end := bytes.LastIndexByte(data, '\n') + 1; end > 0 {
+			return end
+		}
+	}
+	return len(data)
+}
+
+// isContinuation checks if line (without '\n') continues previous one
+func (lw *LogWriter) isContinuation(line []byte) bool {
+	if lw.config.Continuation != nil {
+		return lw.config.Continuation.Match(line)
+	}
+	return len(line) > 0 && (line[0] == ' ' || line[0] == '\t')
+}
+
+// flushPartial writes incomplete record as is. Must be called with lw locked.
+func (lw *LogWriter) flushPartial() error {
+
+	if len(lw.partial) == 0 {
+		return nil
+	}
+
+	_, err := lw.write(lw.partial)
+	lw.partial = lw.partial[:0]
+
+	return err
+}
+
+// flushGroup writes complete lines of kept group. Call it when the group is
+// considered complete because there were no writes for a while. Must be
+// called with lw locked.
+func (lw *LogWriter) flushGroup() error {
+
+	if lw.config.Records != GroupRecords {
+		return nil
+	}
+
+	end := bytes.LastIndexByte(lw.partial, '\n') + 1
+	if end == 0 {
+		return nil
+	}
+
+	_, err := lw.write(lw.partial[:end])
+	lw.partial = append(lw.partial[:0], lw.partial[end:]...)
+
+	return err
+}
+
+func (lw *LogWriter) maxPartialLen() int {
+	if lw.config.BufferSize > maxPartialLen {
+		return lw.config.BufferSize
+	}
+	return maxPartialLen
+}
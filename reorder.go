@@ -0,0 +1,179 @@
+package logwriter
+
+import (
+	"bytes"
+	"container/heap"
+	"time"
+)
+
+// reorderItem is a log item held by reorder window
+type reorderItem struct {
+	ts  time.Time
+	seq uint64
+	p   []byte
+}
+
+// reorderQueue is a min-heap of log items ordered by timestamp, then by arrival
+type reorderQueue struct {
+	items []reorderItem
+	seq   uint64
+
+	// timestamp of the last item pushed, given to items without timestamp
+	last time.Time
+}
+
+func (q *reorderQueue) Len() int { return len(q.items) }
+
+func (q *reorderQueue) Less(i, j int) bool {
+	if q.items[i].ts.Equal(q.items[j].ts) {
+		return q.items[i].seq < q.items[j].seq
+	}
+	return q.items[i].ts.Before(q.items[j].ts)
+}
+
+func (q *reorderQueue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+
+func (q *reorderQueue) Push(x interface{}) { q.items = append(q.items, x.(reorderItem)) }
+
+func (q *reorderQueue) Pop() interface{} {
+	n := len(q.items) - 1
+	item := q.items[n]
+	q.items[n] = reorderItem{}
+	q.items = q.items[:n]
+	return item
+}
+
+// hold copies log item p into reorder window. Must be called with lw locked.
+func (lw *LogWriter) hold(p []byte) {
+
+	q := lw.reorder
+	now := lw.clock.Now()
+
+	parse := lw.config.Timestamp
+	if parse == nil {
+		parse = ParseTimestamp
+	}
+
+	ts, ok := parse(p)
+	switch {
+	case !ok && q.last.IsZero():
+		ts = now
+	case !ok:
+		// continuation of the previous item
+		ts = q.last
+	case ts.After(now):
+		// item is not held longer than window
+		ts = now
+	}
+
+	q.last = ts
+	q.seq++
+	heap.Push(q, reorderItem{ts: ts, seq: q.seq, p: append([]byte(nil), p...)})
+}
+
+// emitReordered writes held log items older than reorder window, or all items if
+// all is true, in timestamp order. Must be called with lw locked.
+func (lw *LogWriter) emitReordered(all bool) error {
+
+	if lw.reorder == nil || lw.reordering {
+		return nil
+	}
+
+	// freezes are postponed while items are written
+	lw.reordering = true
+
+	deadline := lw.clock.Now().Add(-lw.config.ReorderWindow)
+
+	for lw.reorder.Len() > 0 {
+		if !all && lw.reorder.items[0].ts.After(deadline) {
+			break
+		}
+
+		// item is dropped once written, the rest of it stays the first on failure
+		item := &lw.reorder.items[0]
+		n, err := lw.writeRecord(item.p)
+		if n == len(item.p) {
+			heap.Pop(lw.reorder)
+		} else {
+			item.p = item.p[n:]
+		}
+
+		if err != nil {
+			lw.reordering = false
+			return err
+		}
+	}
+
+	lw.reordering = false
+
+	if all {
+		return nil
+	}
+
+	return lw.checkLimits()
+}
+
+// emitByTimer writes held log items older than reorder window
+func (lw *LogWriter) emitByTimer() {
+
+	lw.Lock()
+
+	if err := lw.emitReordered(false); err != nil && lw.errHandler != nil {
+		lw.errHandler(err)
+	}
+
+	lw.Unlock()
+}
+
+// setReorder creates or drops reorder window in accordance with lw.config. Held
+// items must be written before window is dropped. Must be called with lw locked.
+func (lw *LogWriter) setReorder() {
+	switch {
+	case lw.config.ReorderWindow <= 0:
+		lw.reorder = nil
+	case lw.reorder == nil:
+		lw.reorder = &reorderQueue{}
+	}
+}
+
+// parseTimestampLen limits log item head searched by ParseTimestamp
+const parseTimestampLen = 128
+
+// timestampLayouts are tried by ParseTimestamp after RFC 3339, "log" package formats
+var timestampLayouts = []string{"2006/01/02 15:04:05.000000", "2006/01/02 15:04:05"}
+
+// ParseTimestamp extracts time of log item p. It recognizes RFC 3339 time at the
+// beginning of p or after "time=" (slog.TextHandler) or "time":" (slog.JSONHandler),
+// and "2006/01/02 15:04:05[.000000]" written by log package without prefix.
+func ParseTimestamp(p []byte) (time.Time, bool) {
+
+	if len(p) > parseTimestampLen {
+		p = p[:parseTimestampLen]
+	}
+
+	if i := bytes.Index(p, []byte("time=")); i >= 0 {
+		p = p[i+len("time="):]
+	} else if i := bytes.Index(p, []byte(`"time":"`)); i >= 0 {
+		p = p[i+len(`"time":"`):]
+	}
+
+	end := bytes.IndexAny(p, " \t\n\"")
+	if end < 0 {
+		end = len(p)
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, string(p[:end])); err == nil {
+		return t, true
+	}
+
+	for _, layout := range timestampLayouts {
+		if len(p) < len(layout) {
+			continue
+		}
+		if t, err := time.ParseInLocation(layout, string(p[:len(layout)]), time.Local); err == nil {
+			return t, true
+		}
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,137 @@
+package logwriter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// expireInterval returns minimal interval between searches of cold files older than maxAge
+func expireInterval(maxAge time.Duration) time.Duration {
+	if d := maxAge / 10; d < time.Hour {
+		return d
+	}
+	return time.Hour
+}
+
+// expireCold returns func removing cold files of lw made before time before, along with
+// ColdPath template folders left empty. Must be called with lw locked.
+func (lw *LogWriter) expireCold(before time.Time) func() {
+
+	var (
+		fs   = lw.fs
+		t    = lw.coldTemplate
+		uid  = lw.uid
+		ext  = lw.coldFileExtension
+		errf = lw.errHandler
+	)
+
+	root, depth, templated := coldRoot(lw.config.ColdPath, t)
+
+	return func() {
+		_, err := walkCold(fs, t, root, depth, templated, func(name string, fi os.FileInfo, v NameValues) (bool, error) {
+
+			if t.has("uid") && v.UID != uid {
+				// file of another LogWriter sharing ColdPath
+				return false, nil
+			}
+
+			if t.has("ext") && v.Ext != ext {
+				// trace or dump file sharing uid and ColdPath
+				return false, nil
+			}
+
+			made := v.Last
+			if made.IsZero() {
+				made = v.End
+			}
+			if made.IsZero() {
+				made = fi.ModTime()
+			}
+
+			if !made.Before(before) {
+				return false, nil
+			}
+
+			if err := fs.Remove(name); err != nil && !os.IsNotExist(err) {
+				return false, err
+			}
+			return true, nil
+		})
+
+		if err != nil && errf != nil {
+			errf(err)
+		}
+	}
+}
+
+// coldRoot returns the deepest folder of coldPath having no tokens and number of folder
+// levels below it in cold file full name template t. templated is true if coldPath
+// has tokens.
+func coldRoot(coldPath string, t *NameTemplate) (root string, depth int, templated bool) {
+
+	root = coldPath
+	if i := strings.IndexByte(root, '{'); i >= 0 {
+		root, templated = filepath.Dir(root[:i+1]), true
+	}
+	root = filepath.Clean(root)
+
+	rel := strings.TrimPrefix(filepath.ToSlash(t.String()), filepath.ToSlash(root))
+	depth = strings.Count(strings.Trim(rel, "/"), "/")
+
+	return root, depth, templated
+}
+
+// walkCold calls visit for files of folder dir made by template t. Subfolders are
+// visited up to depth levels, they are removed if left empty and prune is true.
+// Returns true if visit returned true (removed file) for some file.
+func walkCold(fs FS, t *NameTemplate, dir string, depth int, prune bool,
+	visit func(name string, fi os.FileInfo, v NameValues) (bool, error)) (bool, error) {
+
+	list, err := fs.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+
+	removed := false
+	for _, fi := range list {
+		name := filepath.Join(dir, fi.Name())
+
+		if fi.IsDir() {
+			if depth == 0 {
+				continue
+			}
+
+			ok, err := walkCold(fs, t, name, depth-1, prune, visit)
+			if err != nil {
+				return removed, err
+			}
+
+			if ok && prune {
+				if left, err := fs.ReadDir(name); err == nil && len(left) == 0 {
+					if err := fs.Remove(name); err != nil {
+						return removed, err
+					}
+				}
+			}
+
+			removed = removed || ok
+			continue
+		}
+
+		v, err := t.Parse(name)
+		if err != nil {
+			// not a cold file
+			continue
+		}
+
+		ok, err := visit(name, fi, v)
+		if err != nil {
+			return removed, err
+		}
+		removed = removed || ok
+	}
+
+	return removed, nil
+}
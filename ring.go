@@ -0,0 +1,285 @@
+package logwriter
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+)
+
+// ring keeps copies of the last log items limited by number of items and total size
+type ring struct {
+	maxItems int
+	maxBytes int
+
+	// circular list of items, oldest at head
+	items [][]byte
+	head  int
+	count int
+	bytes int
+
+	// number of the oldest items captured into trace file already
+	captured int
+}
+
+func newRing(maxItems, maxBytes int) *ring {
+	return &ring{maxItems: maxItems, maxBytes: maxBytes}
+}
+
+// push copies p into the ring dropping the oldest items if limits exceeded
+func (r *ring) push(p []byte) {
+
+	for r.count > 0 && ((r.maxItems > 0 && r.count >= r.maxItems) ||
+		(r.maxBytes > 0 && r.bytes+len(p) > r.maxBytes)) {
+		r.pop()
+	}
+
+	if r.count == len(r.items) {
+		r.grow()
+	}
+
+	i := (r.head + r.count) % len(r.items)
+
+	// reuse memory of dropped item
+	r.items[i] = append(r.items[i][:0], p...)
+	r.count++
+	r.bytes += len(p)
+}
+
+func (r *ring) pop() {
+	r.bytes -= len(r.items[r.head])
+	r.head = (r.head + 1) % len(r.items)
+	r.count--
+	if r.captured > 0 {
+		r.captured--
+	}
+}
+
+// grow doubles ring capacity keeping items order
+func (r *ring) grow() {
+
+	n := 2 * len(r.items)
+	if n == 0 {
+		n = 16
+	}
+	if r.maxItems > 0 && n > r.maxItems {
+		n = r.maxItems
+	}
+
+	items := make([][]byte, n)
+	for i := 0; i < r.count; i++ {
+		items[i] = r.items[(r.head+i)%len(r.items)]
+	}
+
+	r.items = items
+	r.head = 0
+}
+
+// copy returns copies of items, the oldest first
+func (r *ring) copy() [][]byte {
+
+	res := make([][]byte, r.count)
+	for i := range res {
+		res[i] = append([]byte(nil), r.items[(r.head+i)%len(r.items)]...)
+	}
+
+	return res
+}
+
+// capture passes items not captured before into f and marks them captured
+func (r *ring) capture(f func(p []byte)) {
+	for ; r.captured < r.count; r.captured++ {
+		f(r.items[(r.head+r.captured)%len(r.items)])
+	}
+}
+
+// setRecent creates, resizes or drops ring of recent log items in accordance with
+// lw.config. Must be called with lw locked.
+func (lw *LogWriter) setRecent() {
+
+	items, size := lw.config.RecentItems, lw.config.RecentSize
+
+	switch {
+	case items <= 0 && size <= 0:
+		lw.recent = nil
+	case lw.recent == nil:
+		lw.recent = newRing(items, size)
+	case lw.recent.maxItems != items || lw.recent.maxBytes != size:
+		// keep items fitting into new limits, captured ones are not captured again
+		old := lw.recent
+		lw.recent = newRing(items, size)
+		lw.recent.captured = old.captured
+		for _, p := range old.copy() {
+			lw.recent.push(p)
+		}
+	}
+}
+
+// keepRecent saves p in the ring of recent log items if enabled and returns true
+// if p must not be written into hot file. Must be called with lw locked.
+func (lw *LogWriter) keepRecent(p []byte) bool {
+
+	if lw.recent == nil {
+		return false
+	}
+
+	lw.recent.push(p)
+	lw.capture()
+
+	return lw.recentOnly(p)
+}
+
+// recentOnly returns true if p is kept in memory only, see config.RecentOnly
+func (lw *LogWriter) recentOnly(p []byte) bool {
+	return lw.config.RecentOnly != nil && lw.config.RecentOnly(p)
+}
+
+// capture implements flight recorder: log item matching config.CaptureTrigger
+// makes recent items, including the trigger, and config.CaptureAfter following
+// items to be written into trace file. Must be called with lw locked after the
+// last item pushed into the ring.
+func (lw *LogWriter) capture() {
+
+	if lw.trace == nil || lw.config.CaptureTrigger == nil {
+		return
+	}
+
+	last := lw.recent.items[(lw.recent.head+lw.recent.count-1)%len(lw.recent.items)]
+
+	switch {
+	case lw.config.CaptureTrigger(last):
+		lw.captureLeft = lw.config.CaptureAfter
+	case lw.captureLeft > 0:
+		lw.captureLeft--
+	default:
+		return
+	}
+
+	lw.recent.capture(func(p []byte) {
+		if lw.traced(p) && !lw.recentOnly(p) {
+			// written into trace file by Write() itself
+			return
+		}
+
+		if _, err := lw.trace.Write(p); err != nil && lw.errHandler != nil {
+			lw.errHandler(err)
+		}
+	})
+}
+
+// Recent returns copies of the last log items written, the oldest first. It
+// includes items not flushed yet and items kept in memory only, see Config.RecentOnly.
+// Returns nil if Config.RecentItems and Config.RecentSize are 0.
+func (lw *LogWriter) Recent() [][]byte {
+
+	lw.Lock()
+	defer lw.Unlock()
+
+	if lw.recent == nil {
+		return nil
+	}
+
+	return lw.recent.copy()
+}
+
+// DumpRecent writes the last log items into w, see Recent().
+func (lw *LogWriter) DumpRecent(w io.Writer) error {
+
+	for _, p := range lw.Recent() {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DumpRecentFile writes the last log items into new file
+// "uid-20060102-150405.000000.dump" located in config.HotPath. Existing files are never
+// overwritten, sequence suffix "-1", "-2", ... is added to the name instead. Returns file name.
+func (lw *LogWriter) DumpRecentFile() (string, error) {
+
+	lw.RLock()
+	base := filepath.Join(lw.config.HotPath,
+		fmt.Sprintf("%s-%s.%s", lw.uid, lw.clock.Now().Format("20060102-150405.000000"), DumpFileExtension))
+	fs := lw.fs
+	lw.RUnlock()
+
+	var (
+		name string
+		f    File
+		err  error
+	)
+
+	for seq := 0; ; seq++ {
+		name = seqName(base, seq)
+		if f, err = fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666); !os.IsExist(err) {
+			break
+		}
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	if err = lw.DumpRecent(f); err != nil {
+		_ = f.Close()
+		return "", err
+	}
+
+	return name, f.Close()
+}
+
+// DumpRecentOnPanic writes the last log items into dump file and panics again if
+// called by panicking goroutine. Use it as deferred call:
+//
+//	defer lw.DumpRecentOnPanic()
+func (lw *LogWriter) DumpRecentOnPanic() {
+
+	if r := recover(); r != nil {
+		if _, err := lw.DumpRecentFile(); err != nil {
+			lw.RLock()
+			errf := lw.errHandler
+			lw.RUnlock()
+
+			if errf != nil {
+				errf(err)
+			}
+		}
+		panic(r)
+	}
+}
+
+// DumpRecentOnSignal writes the last log items into dump file every time one of
+// signals sig received. Call returned function to stop.
+func (lw *LogWriter) DumpRecentOnSignal(sig ...os.Signal) (stop func()) {
+
+	c := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(c, sig...)
+
+	go func() {
+		for {
+			select {
+			case <-c:
+				if _, err := lw.DumpRecentFile(); err != nil {
+					lw.RLock()
+					errf := lw.errHandler
+					lw.RUnlock()
+
+					if errf != nil {
+						errf(err)
+					}
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(c)
+		close(done)
+	}
+}
@@ -0,0 +1,214 @@
+package logwriter
+
+import (
+	"bytes"
+	"io"
+)
+
+// Level is a severity of log record. Values match log/slog levels, so
+// slog.Level converts to Level directly.
+type Level int
+
+// Log record levels
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+// LevelWriter accepts log records together with their level. Router implements it.
+type LevelWriter interface {
+	WriteLevel(level Level, p []byte) (int, error)
+}
+
+// Route passes log records matching level into Writer, usually LogWriter having
+// own uid, freeze rules and cold path.
+type Route struct {
+	Writer io.Writer
+
+	// Match selects levels passed into Writer. All levels if nil. See MinLevel()
+	// and LevelRange()
+	Match func(level Level) bool
+}
+
+// MinLevel matches level and above.
+func MinLevel(min Level) func(Level) bool {
+	return func(level Level) bool { return level >= min }
+}
+
+// LevelRange matches levels from min to max inclusive.
+func LevelRange(min, max Level) func(Level) bool {
+	return func(level Level) bool { return level >= min && level <= max }
+}
+
+// RouterConfig holds parameters of Router.
+type RouterConfig struct {
+	Routes []Route
+
+	// Parse extracts level of log record passed to Write(). ParseLevel is used if nil
+	Parse func(p []byte) Level
+
+	// Urgent selects levels written by WriteUrgent() into LogWriter routes.
+	// LevelError and above if nil
+	Urgent func(level Level) bool
+}
+
+// Router writes log records into routes selected by record level, so single logger
+// feeds several hot files: everything into uid.log, errors into uid.error.log, etc.
+type Router struct {
+	config RouterConfig
+}
+
+// NewRouter creates Router. Routes are not copied, do not change them afterwards.
+func NewRouter(cfg *RouterConfig) *Router {
+
+	r := &Router{config: *cfg}
+
+	if r.config.Parse == nil {
+		r.config.Parse = ParseLevel
+	}
+
+	if r.config.Urgent == nil {
+		r.config.Urgent = MinLevel(LevelError)
+	}
+
+	return r
+}
+
+// Write passes p into routes matching level extracted by RouterConfig.Parse.
+func (r *Router) Write(p []byte) (int, error) {
+	return r.WriteLevel(r.config.Parse(p), p)
+}
+
+// WriteLevel passes p into routes matching level. Every matching route gets p even
+// if some of them failed, the first error is returned.
+func (r *Router) WriteLevel(level Level, p []byte) (int, error) {
+
+	var (
+		err    error
+		urgent = r.config.Urgent(level)
+	)
+
+	for _, route := range r.config.Routes {
+		if route.Match != nil && !route.Match(level) {
+			continue
+		}
+
+		var werr error
+		if lw, ok := route.Writer.(*LogWriter); ok && urgent {
+			_, werr = lw.WriteUrgent(p)
+		} else {
+			_, werr = route.Writer.Write(p)
+		}
+
+		if werr != nil && err == nil {
+			err = werr
+		}
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close closes route writers implementing io.Closer. Writer shared by several
+// routes is closed once.
+func (r *Router) Close() error {
+
+	var (
+		err    error
+		closed = make(map[io.Writer]bool)
+	)
+
+	for _, route := range r.config.Routes {
+		c, ok := route.Writer.(io.Closer)
+		if !ok || closed[route.Writer] {
+			continue
+		}
+		closed[route.Writer] = true
+
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// levelNames recognized by ParseLevel
+var levelNames = []struct {
+	name  []byte
+	level Level
+}{
+	{[]byte("DEBUG"), LevelDebug},
+	{[]byte("INFO"), LevelInfo},
+	{[]byte("WARN"), LevelWarn},
+	{[]byte("WARNING"), LevelWarn},
+	{[]byte("ERROR"), LevelError},
+	{[]byte("FATAL"), LevelError + 4},
+	{[]byte("PANIC"), LevelError + 4},
+}
+
+// parseLevelLen limits record head searched by ParseLevel
+const parseLevelLen = 128
+
+// ParseLevel returns level named first within the beginning of log record p:
+// DEBUG, INFO, WARN, WARNING, ERROR, FATAL or PANIC. Level name is recognized as
+// value of level field ("level=ERROR" written by slog, "\"level\":\"ERROR\""),
+// in brackets ("[ERROR]") or as a separate word having no lowercase letters before
+// it ("2017-11-27 10:00:00 ERROR"), so level names within message are ignored.
+// Level names are case sensitive. LevelInfo is returned if none found.
+func ParseLevel(p []byte) Level {
+
+	if len(p) > parseLevelLen {
+		p = p[:parseLevelLen]
+	}
+
+	for i := 0; i < len(p); i++ {
+		if !isUpper(p[i]) || i > 0 && isLetter(p[i-1]) {
+			continue
+		}
+
+		for _, ln := range levelNames {
+			end := i + len(ln.name)
+			if bytes.HasPrefix(p[i:], ln.name) && (end == len(p) || !isLetter(p[end])) &&
+				isLevelField(p, i, end) {
+				return ln.level
+			}
+		}
+	}
+
+	return LevelInfo
+}
+
+// isLevelField returns true if word p[start:end] is level field of record p
+func isLevelField(p []byte, start, end int) bool {
+
+	head := p[:start]
+
+	switch {
+	case bytes.HasSuffix(head, []byte("level=")), bytes.HasSuffix(head, []byte(`"level":"`)):
+		return true
+	case start > 0 && p[start-1] == '[' && end < len(p) && p[end] == ']':
+		return true
+	}
+
+	for _, c := range head {
+		if c >= 'a' && c <= 'z' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isUpper(c byte) bool {
+	return c >= 'A' && c <= 'Z'
+}
+
+func isLetter(c byte) bool {
+	return isUpper(c) || c >= 'a' && c <= 'z'
+}
@@ -0,0 +1,342 @@
+package logwriter
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Ordering defines order of log items written through ShardedWriter.
+type Ordering int
+
+// Supported orderings
+const (
+	// ShardOrder keeps order of items written through the same shard only. Write() uses
+	// single shared shard, so order of Write() calls is kept but Write() alone (e.g.
+	// log.New or slog handler over ShardedWriter) is not striped and gains nothing over
+	// LogWriter. Take ShardedWriter.Shard() per goroutine to spread writes over shards
+	ShardOrder Ordering = 0
+
+	// GlobalOrder keeps order of Write() calls. Items are stamped with sequence number
+	// and shards are merged by it. Full shard makes all shards to be flushed
+	GlobalOrder Ordering = 1
+)
+
+// ShardedConfig holds parameters of ShardedWriter.
+type ShardedConfig struct {
+	// Number of shards. Default value is runtime.GOMAXPROCS(0)
+	Shards int
+
+	// Buffer size of each shard. Default value is 64 KB
+	ShardSize int
+
+	// Order of log items in LogWriter
+	Order Ordering
+
+	// Flush shards every FlushInterval. Default value is DefaultShardFlushInterval,
+	// negative value disables flush by timer
+	FlushInterval time.Duration
+}
+
+// DefaultShardFlushInterval is default value of ShardedConfig.FlushInterval
+const DefaultShardFlushInterval = time.Second
+
+// ShardedWriter spreads concurrent writes over lock striped buffers (shards) which
+// are written into LogWriter when full. It reduces lock contention on LogWriter for
+// high core counts. LogWriter buffering is not needed, set its BufferSize to 0.
+type ShardedWriter struct {
+	lw     *LogWriter
+	config ShardedConfig
+	shards []shard
+
+	// round robin shard selector and global sequence for GlobalOrder
+	next uint64
+	seq  uint64
+
+	// serializes GlobalOrder merges and holds merged data
+	mergeMu sync.Mutex
+	merged  []byte
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type shard struct {
+	mu  sync.Mutex
+	buf []byte
+
+	// records stamped by sequence number, used in GlobalOrder
+	records []shardRecord
+
+	// avoid false sharing of neighbour shards
+	_ [64]byte
+}
+
+type shardRecord struct {
+	seq uint64
+	end int
+}
+
+// NewShardedWriter creates ShardedWriter on top of lw.
+func NewShardedWriter(lw *LogWriter, cfg *ShardedConfig) *ShardedWriter {
+
+	sw := &ShardedWriter{lw: lw}
+
+	if cfg != nil {
+		sw.config = *cfg
+	}
+
+	if sw.config.Shards <= 0 {
+		sw.config.Shards = runtime.GOMAXPROCS(0)
+	}
+
+	if sw.config.ShardSize <= 0 {
+		sw.config.ShardSize = 64 * KB
+	}
+
+	if sw.config.FlushInterval == 0 {
+		sw.config.FlushInterval = DefaultShardFlushInterval
+	}
+
+	sw.shards = make([]shard, sw.config.Shards)
+	for i := range sw.shards {
+		sw.shards[i].buf = make([]byte, 0, sw.config.ShardSize)
+	}
+
+	if sw.config.FlushInterval > 0 {
+		sw.stop = make(chan struct{})
+		sw.done = make(chan struct{})
+
+		// timer created before runner() starts, so Clock knows it immediately
+		lw.RLock()
+		t := lw.clock.NewTimer(sw.config.FlushInterval)
+		lw.RUnlock()
+
+		go sw.runner(t)
+	}
+
+	return sw
+}
+
+// Write appends p to the next shard in GlobalOrder and to the shared shard in
+// ShardOrder, so order of Write() calls is kept in both. In ShardOrder concurrent
+// Write() calls contend on the shared shard, use Shard() per goroutine instead.
+func (sw *ShardedWriter) Write(p []byte) (int, error) {
+	if sw.config.Order == GlobalOrder {
+		i := atomic.AddUint64(&sw.next, 1) % uint64(len(sw.shards))
+		return sw.write(int(i), p)
+	}
+	return sw.write(0, p)
+}
+
+// Shard returns writer bound to a single shard. Items written through it keep their
+// order in any Ordering, so take one per goroutine to keep per-goroutine order. Shared
+// shard of Write() is not given in ShardOrder unless it is the only one.
+func (sw *ShardedWriter) Shard() *ShardWriter {
+	next := atomic.AddUint64(&sw.next, 1)
+	if sw.config.Order == GlobalOrder || len(sw.shards) == 1 {
+		return &ShardWriter{sw: sw, i: int(next % uint64(len(sw.shards)))}
+	}
+	return &ShardWriter{sw: sw, i: 1 + int(next%uint64(len(sw.shards)-1))}
+}
+
+// ShardWriter writes into a single shard of ShardedWriter.
+type ShardWriter struct {
+	sw *ShardedWriter
+	i  int
+}
+
+// Write appends p to the shard.
+func (w *ShardWriter) Write(p []byte) (int, error) {
+	return w.sw.write(w.i, p)
+}
+
+func (sw *ShardedWriter) write(i int, p []byte) (int, error) {
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	s := &sw.shards[i]
+
+	if sw.config.Order == GlobalOrder {
+		for {
+			s.mu.Lock()
+			if len(s.buf)+len(p) <= cap(s.buf) {
+				s.buf = append(s.buf, p...)
+				s.records = append(s.records, shardRecord{seq: atomic.AddUint64(&sw.seq, 1), end: len(s.buf)})
+				s.mu.Unlock()
+				return len(p), nil
+			}
+			s.mu.Unlock()
+
+			if len(p) > cap(s.buf) {
+				// oversized item follows everything buffered
+				if err := sw.merge(p); err != nil {
+					return 0, err
+				}
+				return len(p), nil
+			}
+
+			if err := sw.merge(nil); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.buf)+len(p) > cap(s.buf) {
+		if err := sw.flushShard(s); err != nil {
+			return 0, err
+		}
+
+		if len(p) > cap(s.buf) {
+			return sw.lw.Write(p)
+		}
+	}
+
+	s.buf = append(s.buf, p...)
+	return len(p), nil
+}
+
+// flushShard writes shard buffer into LogWriter. Must be called with s.mu locked.
+func (sw *ShardedWriter) flushShard(s *shard) error {
+
+	if len(s.buf) == 0 {
+		return nil
+	}
+
+	_, err := sw.lw.Write(s.buf)
+	s.buf = s.buf[:0]
+	s.records = s.records[:0]
+
+	return err
+}
+
+// merge writes all shards into LogWriter in sequence order followed by extra
+func (sw *ShardedWriter) merge(extra []byte) error {
+
+	sw.mergeMu.Lock()
+	defer sw.mergeMu.Unlock()
+
+	for i := range sw.shards {
+		sw.shards[i].mu.Lock()
+	}
+
+	// shard records are sorted already, pick the smallest head until all consumed
+	heads := make([]int, len(sw.shards))
+	sw.merged = sw.merged[:0]
+
+	for {
+		min := -1
+		for i := range sw.shards {
+			s := &sw.shards[i]
+			if heads[i] < len(s.records) && (min < 0 || s.records[heads[i]].seq < sw.shards[min].records[heads[min]].seq) {
+				min = i
+			}
+		}
+
+		if min < 0 {
+			break
+		}
+
+		s := &sw.shards[min]
+		start := 0
+		if heads[min] > 0 {
+			start = s.records[heads[min]-1].end
+		}
+
+		sw.merged = append(sw.merged, s.buf[start:s.records[heads[min]].end]...)
+		heads[min]++
+	}
+
+	sw.merged = append(sw.merged, extra...)
+
+	var err error
+	if len(sw.merged) > 0 {
+		_, err = sw.lw.Write(sw.merged)
+	}
+
+	for i := range sw.shards {
+		sw.shards[i].buf = sw.shards[i].buf[:0]
+		sw.shards[i].records = sw.shards[i].records[:0]
+		sw.shards[i].mu.Unlock()
+	}
+
+	return err
+}
+
+// Flush writes all shards into LogWriter and flushes LogWriter buffer.
+func (sw *ShardedWriter) Flush() error {
+
+	if err := sw.flushShards(); err != nil {
+		return err
+	}
+
+	return sw.lw.FlushBuffer()
+}
+
+func (sw *ShardedWriter) flushShards() error {
+
+	if sw.config.Order == GlobalOrder {
+		return sw.merge(nil)
+	}
+
+	for i := range sw.shards {
+		s := &sw.shards[i]
+
+		s.mu.Lock()
+		err := sw.flushShard(s)
+		s.mu.Unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close stops flush timer, flushes shards and closes LogWriter.
+func (sw *ShardedWriter) Close() error {
+
+	if sw.stop != nil {
+		close(sw.stop)
+		<-sw.done
+	}
+
+	if err := sw.flushShards(); err != nil {
+		_ = sw.lw.Close()
+		return err
+	}
+
+	return sw.lw.Close()
+}
+
+// runner flushes shards every FlushInterval
+func (sw *ShardedWriter) runner(t Timer) {
+
+	for {
+		select {
+		case <-sw.stop:
+			t.Stop()
+			close(sw.done)
+			return
+		case <-t.C():
+			if err := sw.flushShards(); err != nil {
+				sw.lw.RLock()
+				errf := sw.lw.errHandler
+				sw.lw.RUnlock()
+
+				if errf != nil {
+					errf(err)
+				}
+			}
+
+			_ = t.Reset(sw.config.FlushInterval)
+		}
+	}
+}
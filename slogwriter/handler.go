@@ -0,0 +1,170 @@
+// Package slogwriter provides log/slog Handler writing records through LogWriter.
+//
+// Every record is formatted into a single line and passed to LogWriter by single
+// Write() call, so buffer flushes and hot file freezes never split a record.
+// Records of high level (errors by default) are written by WriteUrgent(), so they
+// reach hot file before Handle() returns.
+//
+// NewLevelHandler() passes records into logwriter.LevelWriter, like logwriter.Router
+// writing errors into separate hot file.
+package slogwriter
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/regorov/logwriter"
+)
+
+// Format of log records.
+type Format int
+
+// Supported formats
+const (
+	// TextFormat formats records as key=value pairs, see slog.TextHandler
+	TextFormat Format = 0
+
+	// JSONFormat formats records as JSON objects, see slog.JSONHandler
+	JSONFormat Format = 1
+)
+
+// Options holds Handler parameters.
+type Options struct {
+	// Format of records. TextFormat by default
+	Format Format
+
+	// AddSource adds source file and line of log statement
+	AddSource bool
+
+	// Level is the minimal level of records handled. slog.LevelInfo if nil
+	Level slog.Leveler
+
+	// UrgentLevel is the minimal level of records flushed immediately. slog.LevelError if nil.
+	// Ignored by NewLevelHandler(), LevelWriter decides itself
+	UrgentLevel slog.Leveler
+
+	// ReplaceAttr rewrites attributes before formatting, see slog.HandlerOptions
+	ReplaceAttr func(groups []string, a slog.Attr) slog.Attr
+}
+
+// Handler is slog.Handler writing into LogWriter.
+type Handler struct {
+	urgentLevel slog.Leveler
+
+	// handlers writing by Write() and WriteUrgent()
+	normal slog.Handler
+	urgent slog.Handler
+
+	// not nil if records are written into LevelWriter, shared by derived handlers
+	lw *levelWriter
+}
+
+// levelWriter passes formatted record into LevelWriter together with record level
+type levelWriter struct {
+	w logwriter.LevelWriter
+
+	// guards level of record being handled
+	mu    sync.Mutex
+	level logwriter.Level
+}
+
+func (w *levelWriter) Write(p []byte) (int, error) {
+	return w.w.WriteLevel(w.level, p)
+}
+
+// urgentWriter passes records to LogWriter.WriteUrgent()
+type urgentWriter struct {
+	lw *logwriter.LogWriter
+}
+
+func (w urgentWriter) Write(p []byte) (int, error) {
+	return w.lw.WriteUrgent(p)
+}
+
+// NewHandler creates Handler writing into lw. Default options are used if opts is nil.
+func NewHandler(lw *logwriter.LogWriter, opts *Options) *Handler {
+
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+
+	if o.UrgentLevel == nil {
+		o.UrgentLevel = slog.LevelError
+	}
+
+	ho := &slog.HandlerOptions{AddSource: o.AddSource, Level: o.Level, ReplaceAttr: o.ReplaceAttr}
+
+	return &Handler{
+		urgentLevel: o.UrgentLevel,
+		normal:      newFormatter(lw, o.Format, ho),
+		urgent:      newFormatter(urgentWriter{lw}, o.Format, ho)}
+}
+
+// NewLevelHandler creates Handler writing records into w together with their
+// level. Default options are used if opts is nil.
+func NewLevelHandler(w logwriter.LevelWriter, opts *Options) *Handler {
+
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+
+	ho := &slog.HandlerOptions{AddSource: o.AddSource, Level: o.Level, ReplaceAttr: o.ReplaceAttr}
+
+	lw := &levelWriter{w: w}
+	f := newFormatter(lw, o.Format, ho)
+
+	return &Handler{urgentLevel: slog.LevelError, normal: f, urgent: f, lw: lw}
+}
+
+func newFormatter(w io.Writer, format Format, ho *slog.HandlerOptions) slog.Handler {
+	if format == JSONFormat {
+		return slog.NewJSONHandler(w, ho)
+	}
+	return slog.NewTextHandler(w, ho)
+}
+
+// Enabled reports whether records of level are handled.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.normal.Enabled(ctx, level)
+}
+
+// Handle formats r and writes it into LogWriter. Records of Options.UrgentLevel
+// and above are flushed before return.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+
+	if h.lw != nil {
+		// formatter makes single Write() call per record
+		h.lw.mu.Lock()
+		defer h.lw.mu.Unlock()
+
+		h.lw.level = logwriter.Level(r.Level)
+		return h.normal.Handle(ctx, r)
+	}
+
+	if r.Level >= h.urgentLevel.Level() {
+		return h.urgent.Handle(ctx, r)
+	}
+	return h.normal.Handle(ctx, r)
+}
+
+// WithAttrs returns Handler adding attrs to every record.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{
+		urgentLevel: h.urgentLevel,
+		normal:      h.normal.WithAttrs(attrs),
+		urgent:      h.urgent.WithAttrs(attrs),
+		lw:          h.lw}
+}
+
+// WithGroup returns Handler qualifying attributes of records by group name.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{
+		urgentLevel: h.urgentLevel,
+		normal:      h.normal.WithGroup(name),
+		urgent:      h.urgent.WithGroup(name),
+		lw:          h.lw}
+}
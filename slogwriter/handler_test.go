@@ -0,0 +1,101 @@
+package slogwriter_test
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/regorov/logwriter"
+	"github.com/regorov/logwriter/logwritertest"
+	"github.com/regorov/logwriter/slogwriter"
+)
+
+func TestHandler(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+
+	lw, err := logwriter.NewLogWriter("slog",
+		&logwriter.Config{BufferSize: logwriter.KB, FS: mem, Mode: logwriter.ProductionMode}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lw.Close()
+
+	logger := slog.New(slogwriter.NewHandler(lw, &slogwriter.Options{Format: slogwriter.JSONFormat,
+		AddSource: true, Level: slog.LevelInfo})).With("service", "test")
+
+	hot := func() string {
+		b, _ := mem.ReadFile("slog.log")
+		return string(b)
+	}
+
+	logger.Debug("skipped")
+	logger.Info("started", "port", 8080)
+
+	if s := hot(); s != "" {
+		t.Fatalf("info record flushed: %q", s)
+	}
+
+	logger.Error("failed", "err", "timeout")
+
+	lines := strings.Split(strings.TrimSuffix(hot(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("error record did not flush buffer: %q", hot())
+	}
+
+	var rec struct {
+		Level   string
+		Msg     string
+		Service string
+		Err     string
+		Source  *struct{ Line int }
+	}
+
+	if err := json.Unmarshal([]byte(lines[1]), &rec); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec.Level != "ERROR" || rec.Msg != "failed" || rec.Service != "test" || rec.Err != "timeout" || rec.Source == nil {
+		t.Errorf("unexpected record %q", lines[1])
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+
+	mem := logwritertest.NewMemFS()
+
+	newWriter := func(uid string) *logwriter.LogWriter {
+		lw, err := logwriter.NewLogWriter(uid,
+			&logwriter.Config{FS: mem, Mode: logwriter.ProductionMode}, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return lw
+	}
+
+	r := logwriter.NewRouter(&logwriter.RouterConfig{Routes: []logwriter.Route{
+		{Writer: newWriter("app")},
+		{Writer: newWriter("app.error"), Match: logwriter.MinLevel(logwriter.LevelError)},
+	}})
+
+	logger := slog.New(slogwriter.NewLevelHandler(r, &slogwriter.Options{Level: slog.LevelDebug})).
+		WithGroup("req")
+
+	logger.Debug("parsed", "id", 1)
+	logger.Error("failed", "id", 1)
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	all, _ := mem.ReadFile("app.log")
+	if lines := strings.Count(string(all), "\n"); lines != 2 {
+		t.Errorf("app.log %q", all)
+	}
+
+	errs, _ := mem.ReadFile("app.error.log")
+	if !strings.Contains(string(errs), "level=ERROR msg=failed req.id=1") || strings.Count(string(errs), "\n") != 1 {
+		t.Errorf("app.error.log %q", errs)
+	}
+}
@@ -0,0 +1,83 @@
+package logwriter
+
+import "errors"
+
+// ErrNoTrace is returned by WriteTrace() if Config.Trace is nil.
+var ErrNoTrace = errors.New("logwriter: trace file is not configured")
+
+// WriteTrace writes p into trace file "uid.trc", see Config.Trace.
+func (lw *LogWriter) WriteTrace(p []byte) (int, error) {
+
+	lw.RLock()
+	trace := lw.trace
+	lw.RUnlock()
+
+	if trace == nil {
+		return 0, ErrNoTrace
+	}
+
+	return trace.Write(p)
+}
+
+// traceConfig returns config of trace file writer taking missing parameters from cfg
+func traceConfig(cfg *Config) *Config {
+
+	tc := *cfg.Trace
+	tc.Trace = nil
+	tc.TraceMatch = nil
+	tc.Mode = cfg.Mode
+
+	if tc.HotPath == "" {
+		tc.HotPath = cfg.HotPath
+	}
+	if tc.ColdPath == "" {
+		tc.ColdPath = cfg.ColdPath
+	}
+	if tc.FS == nil {
+		tc.FS = cfg.FS
+	}
+	if tc.Clock == nil {
+		tc.Clock = cfg.Clock
+	}
+	if tc.HotNameTemplate == "" {
+		tc.HotNameTemplate = cfg.HotNameTemplate
+	}
+	if tc.ColdNameTemplate == "" {
+		tc.ColdNameTemplate = cfg.ColdNameTemplate
+	}
+
+	return &tc
+}
+
+// newTraceWriter creates LogWriter of trace file configured by cfg.Trace
+func newTraceWriter(uid string, cfg *Config, freezeExisting bool, errf func(error)) (*LogWriter, error) {
+	return newLogWriter(uid, traceConfig(cfg), freezeExisting, errf, TraceFileExtension, TraceFileExtension)
+}
+
+// setTrace creates, updates or closes trace file writer in accordance with
+// lw.config.Trace. Must be called with lw locked.
+func (lw *LogWriter) setTrace() error {
+
+	switch {
+	case lw.config.Trace == nil && lw.trace != nil:
+		err := lw.trace.Close()
+		lw.trace = nil
+		return err
+
+	case lw.config.Trace != nil && lw.trace == nil:
+		var err error
+		lw.trace, err = newTraceWriter(lw.uid, &lw.config, false, lw.errHandler)
+		return err
+
+	case lw.config.Trace != nil:
+		return lw.trace.SetConfig(traceConfig(&lw.config))
+	}
+
+	return nil
+}
+
+// traced returns true if log item p has to be written into trace file. Must be
+// called with lw locked.
+func (lw *LogWriter) traced(p []byte) bool {
+	return lw.trace != nil && lw.config.TraceMatch != nil && lw.config.TraceMatch(p)
+}
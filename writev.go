@@ -0,0 +1,59 @@
+package logwriter
+
+import (
+	"io"
+	"os"
+)
+
+// osFiler is implemented by files wrapping *os.File, e.g. preallocFile
+type osFiler interface {
+	osFile() *os.File
+}
+
+// writeBuffers writes bufs into w. Single writev syscall is used on Linux if w
+// is *os.File or wraps it.
+func writeBuffers(w io.Writer, bufs [][]byte) (int64, error) {
+	switch f := w.(type) {
+	case *os.File:
+		return writev(f, bufs)
+	case osFiler:
+		return writev(f.osFile(), bufs)
+	}
+	return writeEach(w, bufs)
+}
+
+// writeEach writes bufs into w one by one
+func writeEach(w io.Writer, bufs [][]byte) (int64, error) {
+
+	var total int64
+
+	for _, b := range bufs {
+		if len(b) == 0 {
+			continue
+		}
+
+		n, err := w.Write(b)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// consume drops n bytes from the beginning of bufs. First remaining buffer is
+// resliced in place.
+func consume(bufs [][]byte, n int64) [][]byte {
+
+	for len(bufs) > 0 && n >= int64(len(bufs[0])) {
+		n -= int64(len(bufs[0]))
+		bufs = bufs[1:]
+	}
+
+	if len(bufs) > 0 {
+		bufs[0] = bufs[0][n:]
+	}
+
+	return bufs
+}
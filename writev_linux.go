@@ -0,0 +1,77 @@
+//go:build linux
+// +build linux
+
+package logwriter
+
+import (
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// maxIovecs is IOV_MAX, limit of buffers passed to single writev syscall
+const maxIovecs = 1024
+
+// writev writes bufs into f with writev syscall, repeating it on short writes
+func writev(f *os.File, bufs [][]byte) (int64, error) {
+
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return writeEach(f, bufs)
+	}
+
+	var (
+		total int64
+		iovs  = make([]syscall.Iovec, 0, len(bufs))
+	)
+
+	for {
+		iovs = iovs[:0]
+		for _, b := range bufs {
+			if len(iovs) == maxIovecs {
+				break
+			}
+			if len(b) == 0 {
+				continue
+			}
+
+			iov := syscall.Iovec{Base: &b[0]}
+			iov.SetLen(len(b))
+			iovs = append(iovs, iov)
+		}
+
+		if len(iovs) == 0 {
+			return total, nil
+		}
+
+		var (
+			n     uintptr
+			errno syscall.Errno
+		)
+
+		err = rc.Write(func(fd uintptr) bool {
+			n, _, errno = syscall.Syscall(syscall.SYS_WRITEV, fd,
+				uintptr(unsafe.Pointer(&iovs[0])), uintptr(len(iovs)))
+			return errno != syscall.EAGAIN
+		})
+
+		if err == nil && errno != 0 {
+			if errno == syscall.EINTR {
+				continue
+			}
+			err = os.NewSyscallError("writev", errno)
+		}
+
+		if err != nil {
+			return total, &os.PathError{Op: "write", Path: f.Name(), Err: err}
+		}
+
+		if n == 0 {
+			return total, io.ErrShortWrite
+		}
+
+		total += int64(n)
+		bufs = consume(bufs, int64(n))
+	}
+}
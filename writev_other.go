@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package logwriter
+
+import "os"
+
+// writev writes bufs into f one by one, writev is used on Linux only
+func writev(f *os.File, bufs [][]byte) (int64, error) {
+	return writeEach(f, bufs)
+}